@@ -0,0 +1,190 @@
+package cleanup
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// reconcileGracePeriod keeps the reconciliation away from files that are still being written: objects are put on disk
+// before they're recorded in the db
+const reconcileGracePeriod = 10 * time.Minute
+
+// ReconcileReport sums up what a reconciliation found and fixed
+type ReconcileReport struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	// FilesScanned and RecordsScanned count what was looked at on disk and in the db
+	FilesScanned   int64 `json:"files_scanned"`
+	RecordsScanned int64 `json:"records_scanned"`
+	// AdoptedFiles were on disk without a record and are now tracked
+	AdoptedFiles int64 `json:"adopted_files"`
+	// DeletedOrphanFiles were on disk without a record and were removed
+	DeletedOrphanFiles int64 `json:"deleted_orphan_files"`
+	// DeletedMisplacedFiles were somewhere the store never looks, usually on a disk that doesn't own them anymore
+	DeletedMisplacedFiles int64 `json:"deleted_misplaced_files"`
+	// DeletedMismatchedObjects had a different size on disk than in the db, both the file and the record were removed
+	DeletedMismatchedObjects int64 `json:"deleted_mismatched_objects"`
+	// DeletedRecords pointed at files that don't exist
+	DeletedRecords int64 `json:"deleted_records"`
+	Errors         int64 `json:"errors"`
+}
+
+// Reconcile makes the db and the disks agree with each other. Files on disk that the db doesn't know about are either
+// adopted or deleted, misplaced files and files whose size doesn't match their record are deleted, and records of
+// objects missing from disk are deleted.
+func Reconcile(dbStore *store.DBBackedStore, objectStore store.ObjectStore, adopt bool) (*ReconcileReport, error) {
+	walker, ok := objectStore.(store.Walker)
+	if !ok {
+		return nil, errors.Err("%s stores can't be reconciled", objectStore.Name())
+	}
+	report := &ReconcileReport{StartedAt: time.Now()}
+	defer func() { report.Duration = time.Since(report.StartedAt).String() }()
+	meta := dbStore.Metadata()
+	err := walker.Walk(func(object store.StoredObject) error {
+		report.FilesScanned++
+		if time.Since(object.ModTime) < reconcileGracePeriod {
+			return nil
+		}
+		if object.Misplaced {
+			reconcileStep(report, &report.DeletedMisplacedFiles, removeFile(object.Path))
+			return nil
+		}
+		record, err := meta.Get(object.Hash)
+		if err != nil {
+			return err
+		}
+		switch {
+		case record == nil && adopt:
+			reconcileStep(report, &report.AdoptedFiles, meta.Insert(store.ObjectRecord{
+				Hash:           object.Hash,
+				Length:         object.Size,
+				LastAccessedAt: object.ModTime,
+			}))
+		case record == nil:
+			reconcileStep(report, &report.DeletedOrphanFiles, removeFile(object.Path))
+		case record.Length != object.Size:
+			reconcileStep(report, &report.DeletedMismatchedObjects, dbStore.Delete(object.Hash, nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	afterHash := ""
+	for {
+		records, err := meta.List(afterHash, 1000)
+		if err != nil {
+			return report, err
+		}
+		if len(records) == 0 {
+			break
+		}
+		afterHash = records[len(records)-1].Hash
+		for _, record := range records {
+			report.RecordsScanned++
+			exists, err := objectStore.Has(record.Hash, nil)
+			if err != nil {
+				reconcileStep(report, nil, err)
+				continue
+			}
+			if !exists {
+				reconcileStep(report, &report.DeletedRecords, meta.Delete(record.Hash))
+			}
+		}
+	}
+	return report, nil
+}
+
+// reconcileStep counts a fix if it went through or an error otherwise
+func reconcileStep(report *ReconcileReport, counter *int64, err error) {
+	if err != nil {
+		report.Errors++
+		logrus.Errorf("[godycdn] reconciliation: %s", errors.FullTrace(err))
+		return
+	}
+	if counter != nil {
+		*counter++
+	}
+}
+
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Err(err)
+}
+
+// Reconciler runs reconciliations in the background, one at a time, and remembers the outcome of the last one
+type Reconciler struct {
+	dbStore     *store.DBBackedStore
+	objectStore store.ObjectStore
+	adopt       bool
+
+	mu      sync.Mutex
+	running bool
+	last    *ReconcileReport
+	lastErr error
+}
+
+// NewReconciler returns an initialized Reconciler pointer. adopt selects whether untracked files are adopted or deleted.
+func NewReconciler(dbStore *store.DBBackedStore, objectStore store.ObjectStore, adopt bool) *Reconciler {
+	return &Reconciler{dbStore: dbStore, objectStore: objectStore, adopt: adopt}
+}
+
+// Start runs a reconciliation in the background. It returns false if one is already running.
+func (r *Reconciler) Start() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return false
+	}
+	r.running = true
+	go func() { _, _ = r.run() }()
+	return true
+}
+
+// Run runs a reconciliation and waits for it to finish. It fails if one is already running.
+func (r *Reconciler) Run() (*ReconcileReport, error) {
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return nil, errors.Err("a reconciliation is already running")
+	}
+	r.running = true
+	r.mu.Unlock()
+	return r.run()
+}
+
+func (r *Reconciler) run() (*ReconcileReport, error) {
+	logrus.Infoln("[godycdn] reconciliation started")
+	report, err := Reconcile(r.dbStore, r.objectStore, r.adopt)
+	if err != nil {
+		logrus.Errorf("[godycdn] reconciliation failed: %s", errors.FullTrace(err))
+	} else {
+		logrus.Infof("[godycdn] reconciliation finished - files: %d, records: %d, adopted: %d, orphans: %d, misplaced: %d, mismatched: %d, missing: %d, errors: %d - it took %s",
+			report.FilesScanned, report.RecordsScanned, report.AdoptedFiles, report.DeletedOrphanFiles, report.DeletedMisplacedFiles,
+			report.DeletedMismatchedObjects, report.DeletedRecords, report.Errors, report.Duration)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = false
+	r.last = report
+	r.lastErr = err
+	return report, err
+}
+
+// Status returns whether a reconciliation is running, along with the report and the error of the last one.
+// The report is nil if no reconciliation ran yet.
+func (r *Reconciler) Status() (bool, *ReconcileReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running, r.last, r.lastErr
+}
@@ -0,0 +1,115 @@
+package cleanup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+)
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		adopt    bool
+		expected ReconcileReport
+		// kept lists the objects still on disk and recorded after the reconciliation
+		kept []string
+	}{
+		{"adopting orphans", true, ReconcileReport{
+			FilesScanned: 5, RecordsScanned: 3, AdoptedFiles: 1,
+			DeletedMisplacedFiles: 1, DeletedMismatchedObjects: 1, DeletedRecords: 1,
+		}, []string{"aa01", "bb02"}},
+		{"deleting orphans", false, ReconcileReport{
+			FilesScanned: 5, RecordsScanned: 2, DeletedOrphanFiles: 1,
+			DeletedMisplacedFiles: 1, DeletedMismatchedObjects: 1, DeletedRecords: 1,
+		}, []string{"aa01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			disk, err := store.NewDiskStore(dir, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := store.NewSQLiteMetadata(filepath.Join(t.TempDir(), "metadata.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer meta.Close()
+			dbs := store.NewDBBackedStore(disk, meta)
+
+			// aa01 is tracked, bb02 isn't and cc03 is in the prefix directory of another object
+			put(t, dbs, "aa01", "tracked")
+			put(t, disk, "bb02", "orphan")
+			write(t, filepath.Join(dir, "dd", "cc03"), "misplaced")
+			// ee04 is recorded with a different size than it has on disk
+			put(t, dbs, "ee04", "mismatched")
+			write(t, filepath.Join(dir, "ee", "ee04"), "mismatched, and longer")
+			// ff05 is recorded but missing from disk
+			if err := meta.Insert(store.ObjectRecord{Hash: "ff05", Length: 7, LastAccessedAt: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			// every file but ab06 was written before the grace period, ab06 could still be about to be recorded
+			old := time.Now().Add(-2 * reconcileGracePeriod)
+			for _, file := range []string{"aa/aa01", "bb/bb02", "dd/cc03", "ee/ee04"} {
+				if err := os.Chtimes(filepath.Join(dir, file), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			put(t, disk, "ab06", "recent")
+
+			report, err := Reconcile(dbs, disk, tt.adopt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.expected.StartedAt, tt.expected.Duration = report.StartedAt, report.Duration
+			if *report != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *report)
+			}
+
+			kept := map[string]bool{}
+			for _, hash := range tt.kept {
+				kept[hash] = true
+			}
+			for _, hash := range []string{"aa01", "bb02", "ee04", "ff05"} {
+				has, err := disk.Has(hash, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				record, err := meta.Get(hash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if has != kept[hash] || (record != nil) != kept[hash] {
+					t.Errorf("%s: expected kept: %t, got on disk: %t, recorded: %t", hash, kept[hash], has, record != nil)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "dd", "cc03")); !os.IsNotExist(err) {
+				t.Errorf("expected the misplaced file to be deleted, got %v", err)
+			}
+			if has, err := disk.Has("ab06", nil); !has || err != nil {
+				t.Errorf("expected the recent file to be left alone, got %t (%v)", has, err)
+			}
+			if record, err := meta.Get("ab06"); record != nil || err != nil {
+				t.Errorf("expected the recent file to be left untracked, got %+v (%v)", record, err)
+			}
+		})
+	}
+}
+
+func put(t *testing.T, s store.ObjectStore, hash, content string) {
+	if err := s.Put(hash, []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func write(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,105 @@
+package cleanup
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/OdyseeTeam/gody-cdn/configs"
+	"github.com/OdyseeTeam/gody-cdn/store"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/extras/stop"
+	"github.com/sirupsen/logrus"
+)
+
+// quarantineRetention is how long corrupted objects are kept around for inspection
+const quarantineRetention = 7 * 24 * time.Hour
+
+// Scrub verifies the checksum of every cached object once per interval, reading at most bytesPerSecond from the disks
+// so that serving traffic isn't affected. Corrupted objects are quarantined and old quarantined objects are removed.
+func Scrub(dbStore *store.DBBackedStore, stopper *stop.Group, diskConfigs []configs.ObjectCacheParams, bytesPerSecond int64, interval time.Duration) {
+	for {
+		doScrub(dbStore, stopper, bytesPerSecond)
+		for _, diskConfig := range diskConfigs {
+			err := pruneQuarantine(filepath.Join(diskConfig.Path, store.QuarantineDir), quarantineRetention)
+			if err != nil {
+				logrus.Error(errors.FullTrace(err))
+			}
+		}
+		select {
+		case <-stopper.Ch():
+			logrus.Infoln("stopping scrubber")
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func doScrub(dbStore *store.DBBackedStore, stopper *stop.Group, bytesPerSecond int64) {
+	startTime := time.Now()
+	var verified, corrupted, failed int64
+	afterHash := ""
+	for {
+		records, err := dbStore.Metadata().List(afterHash, 1000)
+		if err != nil {
+			logrus.Errorf("[godycdn] scrub interrupted: %s", errors.FullTrace(err))
+			return
+		}
+		if len(records) == 0 {
+			break
+		}
+		afterHash = records[len(records)-1].Hash
+		for _, record := range records {
+			if record.Checksum == nil {
+				continue
+			}
+			select {
+			case <-stopper.Ch():
+				return
+			default:
+			}
+			read, err := dbStore.Verify(record.Hash)
+			switch {
+			case errors.Is(err, store.ErrChecksumMismatch):
+				corrupted++
+			case err != nil:
+				failed++
+				logrus.Errorf("error scrubbing %s: %s", record.Hash, errors.FullTrace(err))
+			default:
+				verified++
+			}
+			// throttle by waiting as long as reading the object should have taken at the configured rate
+			select {
+			case <-stopper.Ch():
+				return
+			case <-time.After(time.Duration(float64(read) / float64(bytesPerSecond) * float64(time.Second))):
+			}
+		}
+	}
+	logrus.Infof("[godycdn] scrub finished - verified: %d, corrupted: %d, failed: %d - it took %s", verified, corrupted, failed, time.Since(startTime))
+}
+
+// pruneQuarantine deletes the quarantined objects older than maxAge
+func pruneQuarantine(dir string, maxAge time.Duration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Err(err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > maxAge {
+			err = os.Remove(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				logrus.Errorf("error removing quarantined object: %s", errors.FullTrace(err))
+			}
+		}
+	}
+	return nil
+}
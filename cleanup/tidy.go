@@ -14,35 +14,59 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func SelfCleanup(dbStore *store.DBBackedStore, outerStore store.ObjectStore, stopper *stop.Group, diskConfig configs.ObjectCacheParams, interval time.Duration) {
-	err := doClean(dbStore, outerStore, stopper, diskConfig)
-	if err != nil {
-		logrus.Error(errors.FullTrace(err))
-	}
+// SelfCleanup periodically prunes the least recently accessed objects from every disk that's over its size budget.
+// placement returns the path of the disk an object is stored on, it can be nil if the cache is made of a single disk.
+func SelfCleanup(dbStore *store.DBBackedStore, outerStore store.ObjectStore, stopper *stop.Group, diskConfigs []configs.ObjectCacheParams, placement func(hash string) string, interval time.Duration) {
+	doCleanAll(dbStore, outerStore, stopper, diskConfigs, placement)
 	for {
 		select {
 		case <-stopper.Ch():
 			logrus.Infoln("stopping self cleanup")
 			return
 		case <-time.After(interval):
-			err := doClean(dbStore, outerStore, stopper, diskConfig)
-			if err != nil {
-				logrus.Error(errors.FullTrace(err))
-			}
+			doCleanAll(dbStore, outerStore, stopper, diskConfigs, placement)
 		}
 	}
 }
 
-func doClean(dbStore *store.DBBackedStore, outerStore store.ObjectStore, stopper *stop.Group, diskConfig configs.ObjectCacheParams) error {
-	used, err := GetUsedSpace(dbStore, diskConfig.Path)
-	if err != nil {
-		return err
+func doCleanAll(dbStore *store.DBBackedStore, outerStore store.ObjectStore, stopper *stop.Group, diskConfigs []configs.ObjectCacheParams, placement func(hash string) string) {
+	var usage map[string]int
+	if placement != nil {
+		var err error
+		usage, err = diskUsage(dbStore, placement)
+		if err != nil {
+			logrus.Error(errors.FullTrace(err))
+			return
+		}
+	}
+	for _, diskConfig := range diskConfigs {
+		var match func(hash string) bool
+		var used int
+		var err error
+		if placement == nil {
+			used, err = GetUsedSpace(dbStore, diskConfig.Path)
+		} else {
+			path := diskConfig.Path
+			match = func(hash string) bool { return placement(hash) == path }
+			used = usage[path]
+		}
+		if err == nil {
+			err = doClean(dbStore, outerStore, stopper, diskConfig, match, used)
+		}
+		if err != nil {
+			logrus.Error(errors.FullTrace(err))
+		}
 	}
+}
+
+// doClean prunes the disk if the used space is over its budget. match selects the objects stored on the disk, nil if
+// it's the only one.
+func doClean(dbStore *store.DBBackedStore, outerStore store.ObjectStore, stopper *stop.Group, diskConfig configs.ObjectCacheParams, match func(hash string) bool, used int) error {
 	if used >= diskConfig.GetMaxSize() {
 		startTime := time.Now()
 		pruneAmount := used - diskConfig.GetMaxSize() + int(float64(used)/100.*5)
-		objectsToDelete, err := dbStore.LeastRecentlyAccessedObjects(pruneAmount)
-		logrus.Infof("[godycdn] cleanup triggered. Used: %dG, maxsize: %dG, pruneamount: %dG", used/1024/1024/1024, diskConfig.GetMaxSize()/1024/1024/1024, pruneAmount/1024/1024/1024)
+		objectsToDelete, err := dbStore.LeastRecentlyAccessedObjectsMatching(pruneAmount, match)
+		logrus.Infof("[godycdn] cleanup of %s triggered. Used: %dG, maxsize: %dG, pruneamount: %dG", diskConfig.Path, used/1024/1024/1024, diskConfig.GetMaxSize()/1024/1024/1024, pruneAmount/1024/1024/1024)
 		if err != nil {
 			return err
 		}
@@ -78,7 +102,7 @@ func doClean(dbStore *store.DBBackedStore, outerStore store.ObjectStore, stopper
 			}()
 		}
 		wg.Wait()
-		logrus.Infof("[godycdn] cleanup finished - it took %s", time.Since(startTime))
+		logrus.Infof("[godycdn] cleanup of %s finished - it took %s", diskConfig.Path, time.Since(startTime))
 	}
 	return nil
 }
@@ -95,8 +119,33 @@ func GetUsedSpace(dbStore *store.DBBackedStore, path string) (int, error) {
 		}
 	}
 	if queryDb {
-		return dbStore.UsedSpace(true)
+		return dbStore.UsedSpace()
+	}
+	return getDiskUsage(path)
+}
+
+// diskUsage returns how many bytes the objects tracked by the db take on each disk, going through the db once rather
+// than walking every disk
+func diskUsage(dbStore *store.DBBackedStore, placement func(hash string) string) (map[string]int, error) {
+	usage := make(map[string]int)
+	afterHash := ""
+	for {
+		records, err := dbStore.Metadata().List(afterHash, 1000)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return usage, nil
+		}
+		for _, record := range records {
+			usage[placement(record.Hash)] += int(record.Length)
+		}
+		afterHash = records[len(records)-1].Hash
 	}
+}
+
+// getDiskUsage returns how many bytes are used by the files under path
+func getDiskUsage(path string) (int, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
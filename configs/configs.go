@@ -19,24 +19,205 @@ type DbConfig struct {
 	Password string `json:"password"`
 }
 
+// S3Configs configures an origin. Despite the name, origins can be S3 buckets, HTTP(S) servers, parent gody-cdn
+// nodes or local directories depending on type.
 type S3Configs struct {
+	// Type is either "s3" (the default), "http", "parent" or "local"
+	Type     string `json:"type"`
 	ID       string `json:"id"`
 	Secret   string `json:"secret"`
 	Region   string `json:"region"`
 	Bucket   string `json:"bucket"`
 	Endpoint string `json:"endpoint"`
+	// URL is the base URL of an http or parent origin, object names are appended to it
+	URL string `json:"url"`
+	// Headers are sent with every request to an http or parent origin
+	Headers map[string]string `json:"headers"`
+	// TimeoutSeconds is how long an http or parent origin can take to start answering
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// ParentOrigin is the origin a parent origin is asked to fetch objects from, the name of this origin if empty
+	ParentOrigin string `json:"parent_origin"`
+	// Path is the directory a local origin serves objects from
+	Path string `json:"path"`
+	// NotFoundStatuses are the status codes an http origin answers with for missing objects, 404 if not configured
+	NotFoundStatuses []int `json:"not_found_statuses"`
+	// Name identifies the origin in the fallbacks of the other origins
+	Name string `json:"name"`
+	// Fallbacks are the names of the origins to try, in order, when an object is missing from this one or it's unavailable
+	Fallbacks []string    `json:"fallbacks"`
+	Retry     RetryParams `json:"retry"`
+}
+
+const (
+	OriginS3     = "s3"
+	OriginHTTP   = "http"
+	OriginParent = "parent"
+	OriginLocal  = "local"
+)
+
+// RetryParams configures how requests to an origin failing with transient errors are retried. When max_attempts is
+// set it replaces the retries done by the S3 client.
+type RetryParams struct {
+	MaxAttempts     int `json:"max_attempts"`
+	BaseDelayMs     int `json:"base_delay_ms"`
+	MaxDelayMs      int `json:"max_delay_ms"`
+	DeadlineSeconds int `json:"deadline_seconds"`
 }
 type ObjectCacheParams struct {
 	Path string `json:"path"`
 	Size string `json:"size"`
 }
 
+// ChunkingParams configures caching large objects in chunks. Chunking is disabled when the threshold is empty.
+type ChunkingParams struct {
+	Threshold string `json:"threshold"`
+	ChunkSize string `json:"chunk_size"`
+}
+
+// MemoryCacheParams configures the in-memory tier for small hot objects. It's disabled when the size is empty.
+type MemoryCacheParams struct {
+	Size          string `json:"size"`
+	MaxObjectSize string `json:"max_object_size"`
+}
+
+// ScrubParams configures the background verification of cached objects. It's disabled when the rate is empty.
+type ScrubParams struct {
+	Rate            string `json:"rate"`
+	IntervalSeconds int    `json:"interval_seconds"`
+}
+
+// MetadataParams selects where the cache keeps track of its objects: "mysql" (the default) uses local_db,
+// "sqlite" uses an embedded database kept in the file at path.
+type MetadataParams struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+}
+
+// CircuitBreakerParams configures the circuit breaker put in front of every origin. It's disabled when the failure
+// rate is 0.
+type CircuitBreakerParams struct {
+	FailureRate   float64 `json:"failure_rate"`
+	MinRequests   int     `json:"min_requests"`
+	WindowSeconds int     `json:"window_seconds"`
+	OpenSeconds   int     `json:"open_seconds"`
+	SlowCallMs    int     `json:"slow_call_ms"`
+}
+
+// PeersParams lists sibling gody-cdn nodes to ask for objects before going to the origin
+type PeersParams struct {
+	URLs      []string `json:"urls"`
+	TimeoutMs int      `json:"timeout_ms"`
+}
+
+// ClusterParams makes this node one of the members of a cluster sharing the objects through consistent hashing.
+// self is the URL the other members reach this node at and members the URLs of all the members. Requests for objects
+// owned by another member are proxied to it, or redirected when mode is "redirect".
+type ClusterParams struct {
+	Self                       string   `json:"self"`
+	Members                    []string `json:"members"`
+	Mode                       string   `json:"mode"`
+	HealthCheckIntervalSeconds int      `json:"health_check_interval_seconds"`
+	TimeoutMs                  int      `json:"timeout_ms"`
+}
+
+const (
+	ClusterProxy    = "proxy"
+	ClusterRedirect = "redirect"
+)
+
+// FreshnessRule sets how long the cached objects whose name matches pattern, a regular expression, are served before
+// being revalidated against the origin, and how long they can still be served once stale: while being revalidated
+// and when the origin can't be reached.
+type FreshnessRule struct {
+	Pattern                     string `json:"pattern"`
+	TTLSeconds                  int    `json:"ttl_seconds"`
+	StaleWhileRevalidateSeconds int    `json:"stale_while_revalidate_seconds"`
+	StaleIfErrorSeconds         int    `json:"stale_if_error_seconds"`
+}
+
+// NegativeCacheParams configures how objects missing from the origins are remembered. It's enabled by default,
+// set size to -1 to disable it.
+type NegativeCacheParams struct {
+	Size       int `json:"size"`
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+// ReconcileParams configures the reconciliation of the metadata db with the disks. Files found on disk without
+// a record are deleted unless adopt_orphans is set.
+type ReconcileParams struct {
+	OnStartup    bool `json:"on_startup"`
+	AdoptOrphans bool `json:"adopt_orphans"`
+}
+
+// PrewarmParams configures the jobs fetching objects into the cache through the admin API
+type PrewarmParams struct {
+	Concurrency            int `json:"concurrency"`
+	ManifestTimeoutSeconds int `json:"manifest_timeout_seconds"`
+}
+
+// PrefetchParams configures the prefetching of the HLS segments following the requested ones. It's disabled when
+// segments is 0.
+type PrefetchParams struct {
+	Segments    int `json:"segments"`
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// CompressionParams configures the compression of responses for the clients accepting it. It's disabled when no
+// content types are listed.
+type CompressionParams struct {
+	ContentTypes []string `json:"content_types"`
+	MinSize      string   `json:"min_size"`
+	MaxSize      string   `json:"max_size"`
+}
+
+// AdmissionParams configures which of the objects missing from the cache get cached. policy is "second_hit" to cache
+// objects requested twice within window_seconds, or "tinylfu" to cache objects once they've been requested min_hits
+// times recently. size is how many objects the policy keeps track of. Every object is cached when policy is empty.
+type AdmissionParams struct {
+	Policy        string `json:"policy"`
+	Size          int    `json:"size"`
+	WindowSeconds int    `json:"window_seconds"`
+	MinHits       int    `json:"min_hits"`
+}
+
+const (
+	AdmissionSecondHit = "second_hit"
+	AdmissionTinyLFU   = "tinylfu"
+)
+
+// AdminParams configures the admin API. It's disabled when the token is empty.
+type AdminParams struct {
+	Address string `json:"address"`
+	Token   string `json:"token"`
+}
+
+const (
+	MetadataMySQL  = "mysql"
+	MetadataSQLite = "sqlite"
+)
+
 type Configs struct {
-	SlackToken             string            `json:"slack_token"`
-	S3Origins              []S3Configs       `json:"s3_origins"`
-	LocalDB                DbConfig          `json:"local_db"`
-	DiskCache              ObjectCacheParams `json:"disk_cache"`
-	CleanupIntervalSeconds int               `json:"cleanup_interval_seconds"`
+	SlackToken             string               `json:"slack_token"`
+	S3Origins              []S3Configs          `json:"s3_origins"`
+	LocalDB                DbConfig             `json:"local_db"`
+	DiskCache              ObjectCacheParams    `json:"disk_cache"`
+	DiskCaches             []ObjectCacheParams  `json:"disk_caches"`
+	CleanupIntervalSeconds int                  `json:"cleanup_interval_seconds"`
+	Chunking               ChunkingParams       `json:"chunking"`
+	MemoryCache            MemoryCacheParams    `json:"memory_cache"`
+	Scrub                  ScrubParams          `json:"scrub"`
+	Metadata               MetadataParams       `json:"metadata"`
+	Reconcile              ReconcileParams      `json:"reconcile"`
+	CircuitBreaker         CircuitBreakerParams `json:"circuit_breaker"`
+	Peers                  PeersParams          `json:"peers"`
+	Cluster                ClusterParams        `json:"cluster"`
+	NegativeCache          NegativeCacheParams  `json:"negative_cache"`
+	Freshness              []FreshnessRule      `json:"freshness"`
+	Prewarm                PrewarmParams        `json:"prewarm"`
+	Prefetch               PrefetchParams       `json:"prefetch"`
+	Compression            CompressionParams    `json:"compression"`
+	Admission              AdmissionParams      `json:"admission"`
+	Admin                  AdminParams          `json:"admin"`
 }
 
 var Configuration *Configs
@@ -66,13 +247,348 @@ func (o *ObjectCacheParams) GetMaxSize() int {
 	return int(maxSize)
 }
 
+// Enabled returns true if large objects should be cached in chunks
+func (c *ChunkingParams) Enabled() bool {
+	return c.Threshold != ""
+}
+
+// GetThreshold returns the size above which objects are cached in chunks
+func (c *ChunkingParams) GetThreshold() int64 {
+	return int64(parseSize(c.Threshold, "chunking threshold"))
+}
+
+// GetChunkSize returns the size of each chunk, 8MB if not configured
+func (c *ChunkingParams) GetChunkSize() int64 {
+	if c.ChunkSize == "" {
+		return int64(8 * datasize.MB)
+	}
+	return int64(parseSize(c.ChunkSize, "chunk size"))
+}
+
+// Enabled returns true if small hot objects should be kept in memory
+func (m *MemoryCacheParams) Enabled() bool {
+	return m.Size != ""
+}
+
+// GetMaxSize returns how many bytes of objects can be kept in memory
+func (m *MemoryCacheParams) GetMaxSize() int64 {
+	return int64(parseSize(m.Size, "memory cache size"))
+}
+
+// GetMaxObjectSize returns the size of the biggest object that can be kept in memory, 1MB if not configured
+func (m *MemoryCacheParams) GetMaxObjectSize() int64 {
+	if m.MaxObjectSize == "" {
+		return int64(datasize.MB)
+	}
+	return int64(parseSize(m.MaxObjectSize, "memory cache max object size"))
+}
+
+// Enabled returns true if cached objects should be verified in the background
+func (s *ScrubParams) Enabled() bool {
+	return s.Rate != ""
+}
+
+// GetRate returns how many bytes per second the scrubber can read
+func (s *ScrubParams) GetRate() int64 {
+	return int64(parseSize(s.Rate, "scrub rate"))
+}
+
+// GetInterval returns how often a scrub is started, once a day if not configured
+func (s *ScrubParams) GetInterval() time.Duration {
+	if s.IntervalSeconds <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(s.IntervalSeconds) * time.Second
+}
+
+// GetBackend returns the metadata backend to use, MySQL if not configured
+func (m *MetadataParams) GetBackend() string {
+	if m.Backend == "" {
+		return MetadataMySQL
+	}
+	return m.Backend
+}
+
+// Enabled returns true if the origins should be guarded by circuit breakers
+func (b *CircuitBreakerParams) Enabled() bool {
+	return b.FailureRate > 0
+}
+
+// GetMinRequests returns how many requests are needed before the breaker can open, 20 if not configured
+func (b *CircuitBreakerParams) GetMinRequests() int {
+	if b.MinRequests <= 0 {
+		return 20
+	}
+	return b.MinRequests
+}
+
+// GetWindow returns how long requests are counted for, a minute if not configured
+func (b *CircuitBreakerParams) GetWindow() time.Duration {
+	if b.WindowSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(b.WindowSeconds) * time.Second
+}
+
+// GetOpenDuration returns how long the breaker stays open before probing the origin, 30 seconds if not configured
+func (b *CircuitBreakerParams) GetOpenDuration() time.Duration {
+	if b.OpenSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(b.OpenSeconds) * time.Second
+}
+
+// GetSlowCall returns the latency above which a request counts as failed, 0 if slow requests are tolerated
+func (b *CircuitBreakerParams) GetSlowCall() time.Duration {
+	return time.Duration(b.SlowCallMs) * time.Millisecond
+}
+
+// Enabled returns true if there are peers to ask
+func (p *PeersParams) Enabled() bool {
+	return len(p.URLs) > 0
+}
+
+// GetTimeout returns how long a peer can take to answer, 500ms if not configured
+func (p *PeersParams) GetTimeout() time.Duration {
+	if p.TimeoutMs <= 0 {
+		return 500 * time.Millisecond
+	}
+	return time.Duration(p.TimeoutMs) * time.Millisecond
+}
+
+// Enabled returns true if the node is part of a cluster
+func (c *ClusterParams) Enabled() bool {
+	return len(c.Members) > 0
+}
+
+// Redirect returns true if clients should be redirected to the owner of the object instead of being proxied
+func (c *ClusterParams) Redirect() bool {
+	return c.Mode == ClusterRedirect
+}
+
+// GetHealthCheckInterval returns how often the other members are checked, every 5 seconds if not configured
+func (c *ClusterParams) GetHealthCheckInterval() time.Duration {
+	if c.HealthCheckIntervalSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.HealthCheckIntervalSeconds) * time.Second
+}
+
+// GetTimeout returns how long a member can take to answer health checks and accept connections, 1s if not configured
+func (c *ClusterParams) GetTimeout() time.Duration {
+	if c.TimeoutMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// Enabled returns true if misses should be remembered
+func (n *NegativeCacheParams) Enabled() bool {
+	return n.Size >= 0
+}
+
+// GetSize returns how many misses are remembered, 2000 if not configured
+func (n *NegativeCacheParams) GetSize() int {
+	if n.Size <= 0 {
+		return 2000
+	}
+	return n.Size
+}
+
+// GetTTL returns how long misses are remembered, 5 minutes if not configured
+func (n *NegativeCacheParams) GetTTL() time.Duration {
+	if n.TTLSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(n.TTLSeconds) * time.Second
+}
+
+// GetConcurrency returns how many objects can be prewarmed at once, 4 if not configured
+func (p *PrewarmParams) GetConcurrency() int {
+	if p.Concurrency <= 0 {
+		return 4
+	}
+	return p.Concurrency
+}
+
+// GetManifestTimeout returns how long fetching a playlist of a prewarm manifest can take, 10s if not configured
+func (p *PrewarmParams) GetManifestTimeout() time.Duration {
+	if p.ManifestTimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(p.ManifestTimeoutSeconds) * time.Second
+}
+
+// Enabled returns true if segments should be prefetched
+func (p *PrefetchParams) Enabled() bool {
+	return p.Segments > 0
+}
+
+// GetMaxInFlight returns how many objects can be prefetched at once, 8 if not configured
+func (p *PrefetchParams) GetMaxInFlight() int {
+	if p.MaxInFlight <= 0 {
+		return 8
+	}
+	return p.MaxInFlight
+}
+
+// Enabled returns true if responses should be compressed
+func (c *CompressionParams) Enabled() bool {
+	return len(c.ContentTypes) > 0
+}
+
+// GetMinSize returns the size of the smallest object worth compressing, 1KB if not configured
+func (c *CompressionParams) GetMinSize() int64 {
+	if c.MinSize == "" {
+		return int64(datasize.KB)
+	}
+	return int64(parseSize(c.MinSize, "compression min size"))
+}
+
+// GetMaxSize returns the size of the biggest object compressed, 10MB if not configured
+func (c *CompressionParams) GetMaxSize() int64 {
+	if c.MaxSize == "" {
+		return int64(10 * datasize.MB)
+	}
+	return int64(parseSize(c.MaxSize, "compression max size"))
+}
+
+// Enabled returns true if only some of the missing objects should be cached
+func (a *AdmissionParams) Enabled() bool {
+	return a.Policy != ""
+}
+
+// GetSize returns how many objects the admission policy keeps track of, 100000 if not configured
+func (a *AdmissionParams) GetSize() int {
+	if a.Size <= 0 {
+		return 100000
+	}
+	return a.Size
+}
+
+// GetWindow returns how long objects requested once are remembered, an hour if not configured
+func (a *AdmissionParams) GetWindow() time.Duration {
+	if a.WindowSeconds <= 0 {
+		return time.Hour
+	}
+	return time.Duration(a.WindowSeconds) * time.Second
+}
+
+// GetMinHits returns how many times objects must be requested before they're cached, 2 if not configured
+func (a *AdmissionParams) GetMinHits() int {
+	if a.MinHits <= 0 {
+		return 2
+	}
+	return a.MinHits
+}
+
+// Enabled returns true if the admin API should be served
+func (a *AdminParams) Enabled() bool {
+	return a.Token != ""
+}
+
+// GetAddress returns the address the admin API listens on, only reachable locally on port 2223 if not configured
+func (a *AdminParams) GetAddress() string {
+	if a.Address == "" {
+		return "127.0.0.1:2223"
+	}
+	return a.Address
+}
+
+// parseSize parses a human readable size that must be more than 0
+func parseSize(size string, what string) datasize.ByteSize {
+	var parsed datasize.ByteSize
+	err := parsed.UnmarshalText([]byte(size))
+	if err != nil {
+		logrus.Fatalf(errors.FullTrace(err))
+	}
+	if parsed <= 0 {
+		logrus.Fatalf("%s must be more than 0. Parsed: %dB", what, parsed)
+	}
+	return parsed
+}
+
+// GetType returns the type of the origin, S3 if not configured
+func (s *S3Configs) GetType() string {
+	if s.Type == "" {
+		return OriginS3
+	}
+	return s.Type
+}
+
+// GetTimeout returns how long an http origin can take to start answering, 10 seconds if not configured
+func (s *S3Configs) GetTimeout() time.Duration {
+	if s.TimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(s.TimeoutSeconds) * time.Second
+}
+
 func (s *S3Configs) GetS3AWSConfig() *aws.Config {
-	return &aws.Config{
+	config := &aws.Config{
 		Credentials:      credentials.NewStaticCredentials(s.ID, s.Secret, ""),
 		Region:           &s.Region,
 		Endpoint:         &s.Endpoint,
 		S3ForcePathStyle: aws.Bool(true),
 	}
+	if s.Retry.Enabled() {
+		// retries are handled by the retry policy of the origin
+		config.MaxRetries = aws.Int(0)
+	}
+	return config
+}
+
+// Enabled returns true if the retries are configured
+func (r *RetryParams) Enabled() bool {
+	return r.MaxAttempts > 0
+}
+
+// GetBaseDelay returns the delay before the first retry, 100ms if not configured
+func (r *RetryParams) GetBaseDelay() time.Duration {
+	if r.BaseDelayMs <= 0 {
+		return 100 * time.Millisecond
+	}
+	return time.Duration(r.BaseDelayMs) * time.Millisecond
+}
+
+// GetMaxDelay returns the longest delay between two attempts, 2 seconds if not configured
+func (r *RetryParams) GetMaxDelay() time.Duration {
+	if r.MaxDelayMs <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(r.MaxDelayMs) * time.Millisecond
+}
+
+// GetDeadline returns how long after the first attempt a retry can still be started, 0 for no deadline
+func (r *RetryParams) GetDeadline() time.Duration {
+	return time.Duration(r.DeadlineSeconds) * time.Second
+}
+
+// GetDiskCaches returns the disks making up the cache. disk_caches takes precedence over disk_cache when both are set.
+func (c *Configs) GetDiskCaches() []ObjectCacheParams {
+	if len(c.DiskCaches) > 0 {
+		return c.DiskCaches
+	}
+	return []ObjectCacheParams{c.DiskCache}
+}
+
+// OriginNames returns the name requests can select each origin by, in order. The first two origins are known as
+// legacy and wasabi unless they're named.
+func (c *Configs) OriginNames() []string {
+	names := make([]string, 0, len(c.S3Origins))
+	for i, origin := range c.S3Origins {
+		names = append(names, OriginName(i, origin))
+	}
+	return names
+}
+
+// OriginName returns the name requests can select the origin at the given index by
+func OriginName(index int, origin S3Configs) string {
+	defaults := []string{"legacy", "wasabi"}
+	if origin.Name == "" && index < len(defaults) {
+		return defaults[index]
+	}
+	return origin.Name
 }
 
 func (c *Configs) GetCleanupInterval() time.Duration {
@@ -6,6 +6,7 @@ CREATE TABLE `object`
     `is_stored`        tinyint(1)                       NOT NULL DEFAULT '0',
     `length`           bigint unsigned                           DEFAULT NULL,
     `last_accessed_at` timestamp                        NULL     DEFAULT NULL,
+    `checksum`         int unsigned                              DEFAULT NULL,
     PRIMARY KEY (`id`),
     UNIQUE KEY `id` (`id`),
     UNIQUE KEY `hash_idx` (`hash`),
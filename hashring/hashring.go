@@ -0,0 +1,84 @@
+// Package hashring implements consistent hashing so that keys can be spread over a set of members
+// in a way that adding or removing a member only moves the keys that member owns.
+package hashring
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent hash ring. It's immutable once built so it's safe for concurrent use.
+type Ring struct {
+	points  []uint32
+	owners  map[uint32]string
+	members []string
+}
+
+// DefaultReplicas is how many points each member gets on the ring. More points make the distribution more even.
+const DefaultReplicas = 160
+
+// New builds a ring out of the given members, each placed on the ring replicas times.
+func New(members []string, replicas int) *Ring {
+	r := &Ring{
+		owners:  make(map[uint32]string, len(members)*replicas),
+		members: append([]string(nil), members...),
+	}
+	for _, m := range members {
+		for i := 0; i < replicas; i++ {
+			p := crc32.ChecksumIEEE([]byte(m + "#" + strconv.Itoa(i)))
+			if _, taken := r.owners[p]; taken {
+				continue
+			}
+			r.owners[p] = m
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Members returns the members of the ring
+func (r *Ring) Members() []string {
+	return r.members
+}
+
+// Get returns the member owning the key or an empty string if the ring is empty
+func (r *Ring) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	return r.owners[r.points[r.search(key)]]
+}
+
+// Owners returns up to n distinct members for the key in order of preference: the owner first, followed by the
+// members that would own the key if the ones before them were gone.
+func (r *Ring) Owners(key string, n int) []string {
+	if n > len(r.members) {
+		n = len(r.members)
+	}
+	owners := make([]string, 0, n)
+	if len(r.points) == 0 {
+		return owners
+	}
+	seen := make(map[string]bool, n)
+	start := r.search(key)
+	for i := 0; i < len(r.points) && len(owners) < n; i++ {
+		m := r.owners[r.points[(start+i)%len(r.points)]]
+		if !seen[m] {
+			seen[m] = true
+			owners = append(owners, m)
+		}
+	}
+	return owners
+}
+
+// search returns the index of the first point at or after the hash of the key, wrapping around
+func (r *Ring) search(key string) int {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
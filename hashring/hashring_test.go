@@ -0,0 +1,128 @@
+package hashring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOwners(t *testing.T) {
+	tests := []struct {
+		name     string
+		members  []string
+		n        int
+		expected int
+	}{
+		{"empty ring", nil, 3, 0},
+		{"no owners asked", []string{"a", "b", "c"}, 0, 0},
+		{"owner only", []string{"a", "b", "c"}, 1, 1},
+		{"some members", []string{"a", "b", "c"}, 2, 2},
+		{"every member", []string{"a", "b", "c"}, 3, 3},
+		{"more than the members", []string{"a", "b", "c"}, 5, 3},
+		{"single member", []string{"a"}, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(tt.members, DefaultReplicas)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				owners := r.Owners(key, tt.n)
+				if len(owners) != tt.expected {
+					t.Fatalf("expected %d owners for %s, got %v", tt.expected, key, owners)
+				}
+				if len(owners) > 0 && owners[0] != r.Get(key) {
+					t.Errorf("expected %s to own %s first, got %v", r.Get(key), key, owners)
+				}
+				seen := make(map[string]bool)
+				for _, o := range owners {
+					if seen[o] {
+						t.Fatalf("%s listed twice in %v", o, owners)
+					}
+					seen[o] = true
+				}
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		{"empty ring", nil},
+		{"single member", []string{"a"}},
+		{"several members", []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(tt.members, DefaultReplicas)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				got := r.Get(key)
+				if len(tt.members) == 0 {
+					if got != "" {
+						t.Fatalf("expected no owner for %s, got %s", key, got)
+					}
+					continue
+				}
+				if !contains(tt.members, got) {
+					t.Fatalf("%s isn't a member, owning %s", got, key)
+				}
+				// the ring is deterministic
+				if again := New(tt.members, DefaultReplicas).Get(key); again != got {
+					t.Fatalf("%s owned by %s, then by %s", key, got, again)
+				}
+			}
+		})
+	}
+}
+
+func TestMembershipChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+	}{
+		{"member added", []string{"a", "b", "c"}, []string{"a", "b", "c", "d"}},
+		{"member removed", []string{"a", "b", "c", "d"}, []string{"a", "b", "d"}},
+		{"order changed", []string{"a", "b", "c"}, []string{"c", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := New(tt.before, DefaultReplicas), New(tt.after, DefaultReplicas)
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				was, is := before.Get(key), after.Get(key)
+				// only keys owned by a removed member or taken over by an added one can move
+				if was != is && contains(tt.after, was) && contains(tt.before, is) {
+					t.Fatalf("%s moved from %s to %s", key, was, is)
+				}
+			}
+		})
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	members := []string{"a", "b", "c", "d"}
+	r := New(members, DefaultReplicas)
+	counts := make(map[string]int)
+	const keys = 10000
+	for i := 0; i < keys; i++ {
+		counts[r.Get(fmt.Sprintf("key-%d", i))]++
+	}
+	for _, m := range members {
+		// every member should get its share, give or take half of it
+		if share := keys / len(members); counts[m] < share/2 || counts[m] > share*3/2 {
+			t.Errorf("%s owns %d of %d keys: %v", m, counts[m], keys, counts)
+		}
+	}
+}
+
+func contains(members []string, m string) bool {
+	for _, member := range members {
+		if member == m {
+			return true
+		}
+	}
+	return false
+}
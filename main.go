@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"regexp"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/OdyseeTeam/gody-cdn/cleanup"
 	"github.com/OdyseeTeam/gody-cdn/configs"
+	"github.com/OdyseeTeam/gody-cdn/prewarm"
 	"github.com/OdyseeTeam/gody-cdn/server/http"
 	"github.com/OdyseeTeam/gody-cdn/store"
 
@@ -27,33 +30,175 @@ func main() {
 	if err != nil {
 		logrus.Fatalln(errors.FullTrace(err))
 	}
-	err = os.MkdirAll(configs.Configuration.DiskCache.Path, os.ModePerm)
-	if err != nil {
-		logrus.Fatal(errors.FullTrace(err))
+	if breaker := configs.Configuration.CircuitBreaker; breaker.Enabled() {
+		s3Stores.WithCircuitBreakers(store.BreakerSettings{
+			FailureRate: breaker.FailureRate,
+			MinRequests: breaker.GetMinRequests(),
+			Window:      breaker.GetWindow(),
+			OpenFor:     breaker.GetOpenDuration(),
+			SlowCall:    breaker.GetSlowCall(),
+		})
 	}
-	ds, err := store.NewDiskStore(configs.Configuration.DiskCache.Path, 2)
+	diskCaches := configs.Configuration.GetDiskCaches()
+	var objectStore store.ObjectStore
+	var placement func(hash string) string
+	if len(diskCaches) == 1 {
+		err = os.MkdirAll(diskCaches[0].Path, os.ModePerm)
+		if err != nil {
+			logrus.Fatal(errors.FullTrace(err))
+		}
+		objectStore, err = store.NewDiskStore(diskCaches[0].Path, 2)
+		if err != nil {
+			logrus.Fatal(errors.FullTrace(err))
+		}
+	} else {
+		paths := make([]string, 0, len(diskCaches))
+		for _, d := range diskCaches {
+			paths = append(paths, d.Path)
+		}
+		mds, err := store.NewMultiDiskStore(paths, 2)
+		if err != nil {
+			logrus.Fatal(errors.FullTrace(err))
+		}
+		objectStore = mds
+		placement = mds.DiskFor
+	}
+	meta, err := newMetadataStore(configs.Configuration)
 	if err != nil {
 		logrus.Fatal(errors.FullTrace(err))
 	}
-	localDB := configs.Configuration.LocalDB
-	localDsn := fmt.Sprintf("%s:%s@tcp(%s:3306)/%s", localDB.User, localDB.Password, localDB.Host, localDB.Database)
-	dbs := store.NewDBBackedStore(ds, localDsn)
+	// small objects are verified before they're sent, like the ones the memory tier would hold
+	dbs := store.NewDBBackedStore(objectStore, meta).WithVerifiedSize(configs.Configuration.MemoryCache.GetMaxObjectSize())
 
-	go cleanup.SelfCleanup(dbs, dbs, stopper, configs.Configuration.DiskCache, configs.Configuration.GetCleanupInterval())
+	reconciler := cleanup.NewReconciler(dbs, objectStore, configs.Configuration.Reconcile.AdoptOrphans)
+	if configs.Configuration.Reconcile.OnStartup {
+		reconciler.Start()
+	}
+	go cleanup.SelfCleanup(dbs, dbs, stopper, diskCaches, placement, configs.Configuration.GetCleanupInterval())
+	if scrub := configs.Configuration.Scrub; scrub.Enabled() {
+		go cleanup.Scrub(dbs, stopper, diskCaches, scrub.GetRate(), scrub.GetInterval())
+	}
 
-	finalStore := store.NewCachingStore("nvme-db-store", s3Stores, dbs)
+	var origin store.ObjectStore = s3Stores
+	if peers := configs.Configuration.Peers; peers.Enabled() {
+		origin = store.NewPeerStore(peers.URLs, peers.GetTimeout(), configs.Configuration.OriginNames(), s3Stores)
+	}
+	var negativeCache *store.NegativeCacheStore
+	if nc := configs.Configuration.NegativeCache; nc.Enabled() {
+		negativeCache = store.NewNegativeCacheStore(origin, nc.GetSize(), nc.GetTTL())
+		origin = negativeCache
+	}
+	finalStore := store.NewCachingStore("nvme-db-store", origin, dbs)
+	if chunking := configs.Configuration.Chunking; chunking.Enabled() {
+		finalStore.WithChunking(chunking.GetThreshold(), chunking.GetChunkSize())
+	}
+	if memoryCache := configs.Configuration.MemoryCache; memoryCache.Enabled() {
+		finalStore.WithHotTier(store.NewMemoryStore(memoryCache.GetMaxSize(), memoryCache.GetMaxObjectSize()))
+	}
+	if len(configs.Configuration.Freshness) > 0 {
+		rules, err := freshnessRules(configs.Configuration.Freshness)
+		if err != nil {
+			logrus.Fatal(errors.FullTrace(err))
+		}
+		finalStore.WithFreshness(rules)
+	}
+	if admission := configs.Configuration.Admission; admission.Enabled() {
+		policy, err := admissionPolicy(admission)
+		if err != nil {
+			logrus.Fatal(errors.FullTrace(err))
+		}
+		finalStore.WithAdmission(policy)
+	}
 	defer finalStore.Shutdown()
 
-	httpServer := http.NewServer(finalStore, 4000)
+	httpServer := http.NewServer(finalStore, 4000).WithOrigins(configs.Configuration.OriginNames())
+	if cc := configs.Configuration.Compression; cc.Enabled() {
+		httpServer.WithCompression(http.NewCompression(cc.ContentTypes, cc.GetMinSize(), cc.GetMaxSize()))
+	}
+	if pf := configs.Configuration.Prefetch; pf.Enabled() {
+		httpServer.WithPrefetcher(prewarm.NewPrefetcher(finalStore, pf.Segments, pf.GetMaxInFlight()))
+	}
+	var cluster *http.Cluster
+	if cc := configs.Configuration.Cluster; cc.Enabled() {
+		cluster = http.NewCluster(cc.Self, cc.Members, cc.Redirect(), cc.GetTimeout())
+		cluster.Start(cc.GetHealthCheckInterval())
+		defer cluster.Shutdown()
+		httpServer.WithCluster(cluster)
+	}
 	err = httpServer.Start(":" + strconv.Itoa(2222))
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	defer httpServer.Shutdown()
 
+	if admin := configs.Configuration.Admin; admin.Enabled() {
+		adminServer := http.NewAdminServer(admin.Token).WithReconciler(reconciler).WithOrigins(s3Stores)
+		if cluster != nil {
+			adminServer.WithCluster(cluster)
+		}
+		if negativeCache != nil {
+			adminServer.WithNegativeCache(negativeCache)
+		}
+		adminServer.WithPurge(finalStore, configs.Configuration.OriginNames())
+		pw := configs.Configuration.Prewarm
+		prewarmer := prewarm.NewPrewarmer(finalStore, pw.GetConcurrency(), pw.GetManifestTimeout())
+		defer prewarmer.Shutdown()
+		adminServer.WithPrewarmer(prewarmer, configs.Configuration.OriginNames())
+		err = adminServer.Start(admin.GetAddress())
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer adminServer.Shutdown()
+	}
+
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
 	<-interruptChan
 	// deferred shutdowns happen now
 	stopper.StopAndWait()
 }
+
+// freshnessRules compiles the patterns of the freshness rules
+func freshnessRules(params []configs.FreshnessRule) ([]store.FreshnessRule, error) {
+	rules := make([]store.FreshnessRule, 0, len(params))
+	for _, p := range params {
+		pattern, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, errors.Prefix("invalid freshness pattern "+p.Pattern, err)
+		}
+		rules = append(rules, store.FreshnessRule{
+			Pattern:              pattern,
+			TTL:                  time.Duration(p.TTLSeconds) * time.Second,
+			StaleWhileRevalidate: time.Duration(p.StaleWhileRevalidateSeconds) * time.Second,
+			StaleIfError:         time.Duration(p.StaleIfErrorSeconds) * time.Second,
+		})
+	}
+	return rules, nil
+}
+
+func admissionPolicy(params configs.AdmissionParams) (store.AdmissionPolicy, error) {
+	switch params.Policy {
+	case configs.AdmissionSecondHit:
+		return store.NewSecondHitPolicy(params.GetSize(), params.GetWindow()), nil
+	case configs.AdmissionTinyLFU:
+		return store.NewTinyLFUPolicy(params.GetSize(), params.GetMinHits()), nil
+	default:
+		return nil, errors.Err("unknown admission policy %s", params.Policy)
+	}
+}
+
+func newMetadataStore(config *configs.Configs) (store.MetadataStore, error) {
+	switch config.Metadata.GetBackend() {
+	case configs.MetadataMySQL:
+		localDB := config.LocalDB
+		localDsn := fmt.Sprintf("%s:%s@tcp(%s:3306)/%s", localDB.User, localDB.Password, localDB.Host, localDB.Database)
+		return store.NewMySQLMetadata(localDsn)
+	case configs.MetadataSQLite:
+		if config.Metadata.Path == "" {
+			return nil, errors.Err("the sqlite metadata backend requires a path")
+		}
+		return store.NewSQLiteMetadata(config.Metadata.Path)
+	default:
+		return nil, errors.Err("unknown metadata backend %s", config.Metadata.Backend)
+	}
+}
@@ -0,0 +1,55 @@
+package prewarm
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// uriAttribute matches the URI attribute of the playlist tags pointing at other objects
+var uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// uriTags are the tags whose URI attribute points at an object served along with the playlist. Keys are left out,
+// they're usually served by another service.
+var uriTags = []string{"#EXT-X-MAP:", "#EXT-X-MEDIA:", "#EXT-X-I-FRAME-STREAM-INF:"}
+
+// PlaylistURIs returns the URIs listed by an HLS playlist in the order they appear: the media segments or variant
+// playlists, and the initialization sections, renditions and I-frame playlists given by tags.
+func PlaylistURIs(playlist io.Reader) ([]string, error) {
+	var uris []string
+	scanner := bufio.NewScanner(playlist)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+			continue
+		}
+		for _, tag := range uriTags {
+			if strings.HasPrefix(line, tag) {
+				if m := uriAttribute.FindStringSubmatch(line); m != nil && m[1] != "" {
+					uris = append(uris, m[1])
+				}
+				break
+			}
+		}
+	}
+	return uris, errors.Err(scanner.Err())
+}
+
+// IsPlaylist returns true if the object is an HLS playlist, judging by its name
+func IsPlaylist(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".m3u8")
+}
+
+// ObjectName returns the name of the object served at the URL, the same way the server derives it from the path
+func ObjectName(u *url.URL) string {
+	return strings.TrimPrefix(strings.ReplaceAll(u.Path, "/t-na/", ""), "/")
+}
@@ -0,0 +1,167 @@
+package prewarm
+
+import (
+	"bytes"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// playlistsRemembered is how many playlists the prefetcher keeps track of
+	playlistsRemembered = 10000
+	// reparseInterval keeps live playlists, requested over and over by every viewer, from being parsed all the time
+	reparseInterval = 2 * time.Second
+)
+
+// playlist is what's known about a media playlist: its entries in order, and the position of each one
+type playlist struct {
+	entries  []string
+	position map[string]int
+	parsedAt time.Time
+}
+
+// Prefetcher warms the segments a viewer is about to request. The playlists requested through the server are parsed,
+// and whenever one of their segments is requested the next ones are fetched into the cache in the background. At most
+// maxInFlight objects are fetched at once: when the budget is used up, prefetches are dropped rather than queued, so
+// that they can't hold back the requests of viewers.
+type Prefetcher struct {
+	cache    Cache
+	segments int
+	slots    chan struct{}
+
+	// playlists maps playlist names to their entries, and owners maps segment names to the name of their playlist
+	playlists gcache.Cache
+	owners    gcache.Cache
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+// NewPrefetcher returns an initialized Prefetcher pointer warming the given number of segments after each one that's
+// requested
+func NewPrefetcher(cache Cache, segments, maxInFlight int) *Prefetcher {
+	return &Prefetcher{
+		cache:     cache,
+		segments:  segments,
+		slots:     make(chan struct{}, maxInFlight),
+		playlists: gcache.New(playlistsRemembered).LRU().Build(),
+		owners:    gcache.New(playlistsRemembered * 20).LRU().Build(),
+		inFlight:  make(map[string]bool),
+	}
+}
+
+// Requested tells the prefetcher the object was requested. Playlists are parsed, and the segments following a
+// requested segment are warmed. It never blocks.
+func (p *Prefetcher) Requested(name string, extra interface{}) {
+	if IsPlaylist(name) {
+		if pl, err := p.playlists.Get(name); err == nil && time.Since(pl.(*playlist).parsedAt) < reparseInterval {
+			return
+		}
+		p.background(name, func() {
+			err := p.parse(name, extra)
+			if err != nil {
+				log.Debugf("error parsing playlist %s for prefetching: %s", name, errors.FullTrace(err))
+			}
+		})
+		return
+	}
+	owner, err := p.owners.Get(name)
+	if err != nil {
+		return
+	}
+	pl, err := p.playlists.Get(owner)
+	if err != nil {
+		return
+	}
+	entries := pl.(*playlist)
+	i, ok := entries.position[name]
+	if !ok {
+		return
+	}
+	for _, next := range entries.entries[i+1 : min(i+1+p.segments, len(entries.entries))] {
+		p.warm(next, extra)
+	}
+}
+
+// warm fetches the object into the cache in the background if it isn't already cached
+func (p *Prefetcher) warm(name string, extra interface{}) {
+	p.background(name, func() {
+		cached, err := p.cache.HasCached(name, extra)
+		if err != nil || cached {
+			return
+		}
+		err = p.cache.Warm(name, extra)
+		if err != nil {
+			log.Debugf("error prefetching %s: %s", name, errors.FullTrace(err))
+		}
+	})
+}
+
+// background runs fn in the background if the budget allows it and nothing else is being done with the object
+func (p *Prefetcher) background(name string, fn func()) {
+	p.mu.Lock()
+	if p.inFlight[name] {
+		p.mu.Unlock()
+		return
+	}
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		p.mu.Unlock()
+		log.Debugf("prefetch budget used up, not prefetching %s", name)
+		return
+	}
+	p.inFlight[name] = true
+	p.mu.Unlock()
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			delete(p.inFlight, name)
+			p.mu.Unlock()
+			<-p.slots
+		}()
+		fn()
+	}()
+}
+
+// parse reads the playlist from the cache and remembers its segments. Master playlists only list other playlists,
+// they have nothing to prefetch.
+func (p *Prefetcher) parse(name string, extra interface{}) error {
+	content, _, err := p.cache.Get(name, extra)
+	if err != nil {
+		return err
+	}
+	uris, err := PlaylistURIs(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	base := &url.URL{Path: "/" + name}
+	pl := &playlist{position: make(map[string]int, len(uris)), parsedAt: time.Now()}
+	for _, uri := range uris {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+		entry := ObjectName(base.ResolveReference(ref))
+		if IsPlaylist(entry) {
+			continue
+		}
+		if _, seen := pl.position[entry]; seen {
+			continue
+		}
+		pl.position[entry] = len(pl.entries)
+		pl.entries = append(pl.entries, entry)
+	}
+	if len(pl.entries) == 0 {
+		return nil
+	}
+	for _, entry := range pl.entries {
+		_ = p.owners.Set(entry, name)
+	}
+	return errors.Err(p.playlists.Set(name, pl))
+}
@@ -0,0 +1,315 @@
+package prewarm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/extras/stop"
+	"github.com/lbryio/reflector.go/shared"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxJobs is how many jobs are remembered, the oldest finished ones are forgotten first. No job can be submitted while
+	// that many are running.
+	maxJobs = 100
+	// maxFailures is how many failed objects are listed in the status of a job
+	maxFailures = 100
+	// maxPlaylists is how many playlists are fetched when expanding a manifest, variant playlists included
+	maxPlaylists = 50
+)
+
+// Cache is the store objects are warmed into
+type Cache interface {
+	// HasCached returns true if the object is cached, without asking the origin
+	HasCached(originalName string, extra interface{}) (bool, error)
+	// Get returns the object
+	Get(originalName string, extra interface{}) ([]byte, shared.BlobTrace, error)
+	// Warm caches the object if it isn't already
+	Warm(originalName string, extra interface{}) error
+}
+
+// Request describes the objects a job should warm: the listed names, and the objects listed by the HLS manifest at
+// the Manifest URL if it's set, all of them from the origin selected by Extra
+type Request struct {
+	Names    []string
+	Manifest string
+	// Origin is the name of the origin, it's only reported in the status of the job
+	Origin string
+	Extra  interface{}
+}
+
+// Job is the status of a prewarm job
+type Job struct {
+	ID         string     `json:"id"`
+	Origin     string     `json:"origin"`
+	Manifest   string     `json:"manifest,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Running    bool       `json:"running"`
+	Total      int        `json:"total"`
+	// Done objects were fetched into the cache, Skipped ones were already cached
+	Done    int `json:"done"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// Failures lists the error of the first failed objects
+	Failures map[string]string `json:"failures,omitempty"`
+	// Error is set when the manifest couldn't be read
+	Error string `json:"error,omitempty"`
+}
+
+// Prewarmer runs jobs fetching objects into the cache in the background, so that they're cached before they're
+// requested. At most concurrency objects are fetched at once across all jobs.
+type Prewarmer struct {
+	cache  Cache
+	slots  chan struct{}
+	client *http.Client
+	grp    *stop.Group
+
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+// NewPrewarmer returns an initialized Prewarmer pointer. manifestTimeout limits how long fetching each playlist of a
+// manifest can take.
+func NewPrewarmer(cache Cache, concurrency int, manifestTimeout time.Duration) *Prewarmer {
+	return &Prewarmer{
+		cache:  cache,
+		slots:  make(chan struct{}, concurrency),
+		client: &http.Client{Timeout: manifestTimeout},
+		grp:    stop.New(),
+	}
+}
+
+// Submit starts a job and returns its initial status. It returns false without starting the job if too many jobs are
+// running already.
+func (p *Prewarmer) Submit(req Request) (Job, bool) {
+	job := &Job{
+		ID:        newJobID(),
+		Origin:    req.Origin,
+		Manifest:  req.Manifest,
+		CreatedAt: time.Now(),
+		Running:   true,
+	}
+	p.mu.Lock()
+	if !p.remember(job) {
+		p.mu.Unlock()
+		return Job{}, false
+	}
+	status := job.copy()
+	p.mu.Unlock()
+
+	p.grp.Add(1)
+	go func() {
+		defer p.grp.Done()
+		p.run(job, req)
+	}()
+	return status, true
+}
+
+// Job returns the status of the job with the given id, or false if there's no such job
+func (p *Prewarmer) Job(id string) (Job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, job := range p.jobs {
+		if job.ID == id {
+			return job.copy(), true
+		}
+	}
+	return Job{}, false
+}
+
+// Jobs returns the status of the remembered jobs, oldest first
+func (p *Prewarmer) Jobs() []Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	jobs := make([]Job, 0, len(p.jobs))
+	for _, job := range p.jobs {
+		jobs = append(jobs, job.copy())
+	}
+	return jobs
+}
+
+// Shutdown stops the running jobs, waiting for the objects being fetched
+func (p *Prewarmer) Shutdown() {
+	p.grp.StopAndWait()
+}
+
+// remember adds the job to the list, forgetting the oldest finished job if the list is full. It returns false if the
+// list is full of running jobs, leaving it as is. It must be called with the lock held.
+func (p *Prewarmer) remember(job *Job) bool {
+	if len(p.jobs) >= maxJobs {
+		forgotten := false
+		for i, j := range p.jobs {
+			if !j.Running {
+				p.jobs = append(p.jobs[:i], p.jobs[i+1:]...)
+				forgotten = true
+				break
+			}
+		}
+		if !forgotten {
+			return false
+		}
+	}
+	p.jobs = append(p.jobs, job)
+	return true
+}
+
+func (p *Prewarmer) run(job *Job, req Request) {
+	names := req.Names
+	if req.Manifest != "" {
+		listed, err := p.manifestNames(req.Manifest)
+		if err != nil {
+			log.Errorf("error reading prewarm manifest %s: %s", req.Manifest, errors.FullTrace(err))
+			p.mu.Lock()
+			job.Error = err.Error()
+			p.mu.Unlock()
+		}
+		names = append(names, listed...)
+	}
+	names = unique(names)
+	p.mu.Lock()
+	job.Total = len(names)
+	p.mu.Unlock()
+
+	wg := &sync.WaitGroup{}
+	for _, name := range names {
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.grp.Ch():
+			wg.Wait()
+			p.finish(job)
+			return
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-p.slots }()
+			skipped, err := p.warm(name, req.Extra)
+			p.record(job, name, skipped, err)
+		}(name)
+	}
+	wg.Wait()
+	p.finish(job)
+}
+
+// warm fetches the object into the cache. It returns true if the object was already cached.
+func (p *Prewarmer) warm(name string, extra interface{}) (bool, error) {
+	cached, err := p.cache.HasCached(name, extra)
+	if err != nil {
+		return false, err
+	}
+	if cached {
+		return true, nil
+	}
+	return false, p.cache.Warm(name, extra)
+}
+
+func (p *Prewarmer) record(job *Job, name string, skipped bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err != nil:
+		job.Failed++
+		if job.Failures == nil {
+			job.Failures = make(map[string]string)
+		}
+		if len(job.Failures) < maxFailures {
+			job.Failures[name] = err.Error()
+		}
+	case skipped:
+		job.Skipped++
+	default:
+		job.Done++
+	}
+}
+
+func (p *Prewarmer) finish(job *Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Running = false
+	log.Infof("prewarm job %s finished - total: %d, done: %d, skipped: %d, failed: %d", job.ID, job.Total, job.Done, job.Skipped, job.Failed)
+}
+
+// manifestNames returns the names of the manifest and of the objects it lists, following variant playlists. URIs are
+// resolved against the URL of their playlist and named after their path, so the manifest should be given by its URL
+// on the CDN (or on an origin serving objects under their name).
+func (p *Prewarmer) manifestNames(manifest string) ([]string, error) {
+	base, err := url.Parse(manifest)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	names := []string{ObjectName(base)}
+	playlists := []*url.URL{base}
+	for fetched := 0; len(playlists) > 0 && fetched < maxPlaylists; fetched++ {
+		playlist := playlists[0]
+		playlists = playlists[1:]
+		uris, err := p.fetchPlaylist(playlist.String())
+		if err != nil {
+			return names, err
+		}
+		for _, uri := range uris {
+			ref, err := url.Parse(uri)
+			if err != nil {
+				log.Debugf("skipping invalid playlist entry %s: %s", uri, err.Error())
+				continue
+			}
+			resolved := playlist.ResolveReference(ref)
+			names = append(names, ObjectName(resolved))
+			if IsPlaylist(resolved.Path) {
+				playlists = append(playlists, resolved)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (p *Prewarmer) fetchPlaylist(playlistURL string) ([]string, error) {
+	res, err := p.client.Get(playlistURL)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Err("unexpected status %d fetching %s", res.StatusCode, playlistURL)
+	}
+	return PlaylistURIs(res.Body)
+}
+
+func (j *Job) copy() Job {
+	c := *j
+	if j.Failures != nil {
+		c.Failures = make(map[string]string, len(j.Failures))
+		for k, v := range j.Failures {
+			c.Failures[k] = v
+		}
+	}
+	return c
+}
+
+// unique returns the non-empty names in their original order, without duplicates
+func unique(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	result := make([]string, 0, len(names))
+	for _, n := range names {
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		result = append(result, n)
+	}
+	return result
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,55 @@
+package prewarm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbryio/reflector.go/shared"
+)
+
+// blockingCache holds every object being warmed until it's released
+type blockingCache struct {
+	release chan struct{}
+}
+
+func (b *blockingCache) HasCached(originalName string, extra interface{}) (bool, error) {
+	return false, nil
+}
+
+func (b *blockingCache) Get(originalName string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	return nil, shared.BlobTrace{}, nil
+}
+
+func (b *blockingCache) Warm(originalName string, extra interface{}) error {
+	<-b.release
+	return nil
+}
+
+func TestSubmitTooManyJobs(t *testing.T) {
+	cache := &blockingCache{release: make(chan struct{})}
+	p := NewPrewarmer(cache, 1, 0)
+	defer p.Shutdown()
+	for i := 0; i < maxJobs; i++ {
+		if _, ok := p.Submit(Request{Names: []string{"object"}}); !ok {
+			t.Fatalf("job %d refused", i)
+		}
+	}
+	if _, ok := p.Submit(Request{Names: []string{"object"}}); ok {
+		t.Fatal("job accepted while the maximum number of jobs are running")
+	}
+	if jobs := p.Jobs(); len(jobs) != maxJobs {
+		t.Fatalf("expected %d jobs, got %d", maxJobs, len(jobs))
+	}
+
+	// a finished job makes room for a new one
+	cache.release <- struct{}{}
+	for finished := false; !finished; time.Sleep(time.Millisecond) {
+		for _, job := range p.Jobs() {
+			finished = finished || !job.Running
+		}
+	}
+	if _, ok := p.Submit(Request{Names: []string{"object"}}); !ok {
+		t.Fatal("job refused after one finished")
+	}
+	close(cache.release)
+}
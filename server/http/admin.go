@@ -0,0 +1,272 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/OdyseeTeam/gody-cdn/cleanup"
+	"github.com/OdyseeTeam/gody-cdn/prewarm"
+	"github.com/OdyseeTeam/gody-cdn/store"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/extras/stop"
+
+	nice "github.com/ekyoung/gin-nice-recovery"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// AdminServer serves the operational endpoints. It listens on its own address so it can be kept off the public
+// network, and every request has to carry the configured token as a bearer token.
+type AdminServer struct {
+	token string
+	grp   *stop.Group
+
+	reconciler *cleanup.Reconciler
+	origins    *store.MultiS3Store
+	cluster    *Cluster
+	negative   *store.NegativeCacheStore
+	cache      *store.CachingStore
+	prewarmer  *prewarm.Prewarmer
+	// cacheOrigins are the origins purges and prewarms can select, by name
+	cacheOrigins map[string]store.MultiS3Extras
+}
+
+// NewAdminServer returns an initialized AdminServer pointer.
+func NewAdminServer(token string) *AdminServer {
+	return &AdminServer{
+		token: token,
+		grp:   stop.New(),
+	}
+}
+
+// WithReconciler exposes the reconciliation of the metadata db with the disks
+func (a *AdminServer) WithReconciler(reconciler *cleanup.Reconciler) *AdminServer {
+	a.reconciler = reconciler
+	return a
+}
+
+// WithOrigins exposes the health of the origins
+func (a *AdminServer) WithOrigins(origins *store.MultiS3Store) *AdminServer {
+	a.origins = origins
+	return a
+}
+
+// WithCluster exposes the members of the cluster and their health
+func (a *AdminServer) WithCluster(cluster *Cluster) *AdminServer {
+	a.cluster = cluster
+	return a
+}
+
+// WithNegativeCache allows clearing the misses remembered by the negative cache
+func (a *AdminServer) WithNegativeCache(negative *store.NegativeCacheStore) *AdminServer {
+	a.negative = negative
+	return a
+}
+
+// WithPurge allows dropping objects from the cache, by name or by prefix. originNames holds the name of each origin
+// in order, as given to Server.WithOrigins.
+func (a *AdminServer) WithPurge(cache *store.CachingStore, originNames []string) *AdminServer {
+	a.cache = cache
+	a.cacheOrigins = namedOrigins(originNames)
+	return a
+}
+
+// WithPrewarmer allows fetching objects into the cache ahead of requests. originNames holds the name of each origin
+// in order, as given to Server.WithOrigins.
+func (a *AdminServer) WithPrewarmer(prewarmer *prewarm.Prewarmer, originNames []string) *AdminServer {
+	a.prewarmer = prewarmer
+	a.cacheOrigins = namedOrigins(originNames)
+	return a
+}
+
+// Shutdown gracefully shuts down the admin server.
+func (a *AdminServer) Shutdown() {
+	log.Debug("shutting down admin server")
+	a.grp.StopAndWait()
+	log.Debug("admin server stopped")
+}
+
+// Start starts the admin listener.
+func (a *AdminServer) Start(address string) error {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(gin.Logger())
+	router.Use(nice.Recovery(a.recoveryHandler))
+	router.Use(a.authenticate)
+	if a.reconciler != nil {
+		router.GET("/reconcile", a.reconcileStatus)
+		router.POST("/reconcile", a.startReconcile)
+	}
+	if a.origins != nil {
+		router.GET("/origins", a.originsStatus)
+	}
+	if a.cluster != nil {
+		router.GET("/cluster", a.clusterStatus)
+	}
+	if a.negative != nil {
+		router.DELETE("/negative-cache", a.clearNegativeCache)
+	}
+	if a.cache != nil {
+		router.POST("/purge", a.purge)
+		router.GET("/admission", a.admissionStats)
+	}
+	if a.prewarmer != nil {
+		router.POST("/prewarm", a.startPrewarm)
+		router.GET("/prewarm", a.prewarmJobs)
+		router.GET("/prewarm/:id", a.prewarmJob)
+	}
+	srv := &http.Server{
+		Addr:    address,
+		Handler: router,
+	}
+	go func() {
+		<-a.grp.Ch()
+		shutdownListener(srv)
+	}()
+	a.grp.Add(1)
+	go func() {
+		defer a.grp.Done()
+		log.Println("admin server listening on " + address)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("listen: %s\n", err)
+		}
+	}()
+	return nil
+}
+
+func (a *AdminServer) authenticate(c *gin.Context) {
+	// a bare token isn't accepted, it has to come as a bearer token
+	token, bearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !bearer || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+func (a *AdminServer) reconcileStatus(c *gin.Context) {
+	running, report, err := a.reconciler.Status()
+	response := gin.H{"running": running, "last_report": report}
+	if err != nil {
+		response["last_error"] = err.Error()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (a *AdminServer) startReconcile(c *gin.Context) {
+	if !a.reconciler.Start() {
+		c.JSON(http.StatusConflict, gin.H{"error": "a reconciliation is already running"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"running": true})
+}
+
+func (a *AdminServer) originsStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, a.origins.Status())
+}
+
+func (a *AdminServer) clusterStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, a.cluster.Status())
+}
+
+// clearNegativeCache forgets the misses of the object given by the object parameter, or all of them without it
+func (a *AdminServer) clearNegativeCache(c *gin.Context) {
+	if object := c.Query("object"); object != "" {
+		c.JSON(http.StatusOK, gin.H{"cleared": a.negative.Forget(object)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cleared": a.negative.Clear()})
+}
+
+// purge drops from the cache the object given by the name parameter, or all the objects whose name starts with the
+// prefix parameter. The origin parameter restricts the purge to the objects fetched from that origin.
+func (a *AdminServer) purge(c *gin.Context) {
+	name, prefix := c.Query("name"), c.Query("prefix")
+	if (name == "") == (prefix == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of name and prefix must be given"})
+		return
+	}
+	origin := -1
+	if originName := c.Query("origin"); originName != "" {
+		extras, ok := a.cacheOrigins[originName]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown origin " + originName})
+			return
+		}
+		origin = extras.S3Index
+	}
+	var purged int
+	var err error
+	if name != "" {
+		purged, err = a.cache.Purge(name, origin)
+	} else {
+		purged, err = a.cache.PurgePrefix(prefix, origin)
+	}
+	if err != nil {
+		log.Errorf("error purging the cache: %s", errors.FullTrace(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "purged": purged})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+func (a *AdminServer) admissionStats(c *gin.Context) {
+	c.JSON(http.StatusOK, a.cache.AdmissionStats())
+}
+
+// prewarmRequest lists the objects to prewarm, by name or through the URL of an HLS manifest
+type prewarmRequest struct {
+	Names    []string `json:"names"`
+	Manifest string   `json:"manifest"`
+	Origin   string   `json:"origin"`
+}
+
+// startPrewarm starts a job fetching the requested objects into the cache
+func (a *AdminServer) startPrewarm(c *gin.Context) {
+	var req prewarmRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Names) == 0 && req.Manifest == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "names or manifest must be given"})
+		return
+	}
+	if req.Origin == "" {
+		req.Origin = "legacy"
+	}
+	extras, ok := a.cacheOrigins[req.Origin]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown origin " + req.Origin})
+		return
+	}
+	job, ok := a.prewarmer.Submit(prewarm.Request{Names: req.Names, Manifest: req.Manifest, Origin: req.Origin, Extra: extras})
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many prewarm jobs are running"})
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (a *AdminServer) prewarmJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, a.prewarmer.Jobs())
+}
+
+func (a *AdminServer) prewarmJob(c *gin.Context) {
+	job, ok := a.prewarmer.Job(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (a *AdminServer) recoveryHandler(c *gin.Context, err interface{}) {
+	c.JSON(500, gin.H{
+		"title": "Error",
+		"err":   err,
+	})
+}
@@ -0,0 +1,228 @@
+package http
+
+import (
+	"context"
+	goerrors "errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/OdyseeTeam/gody-cdn/hashring"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/extras/stop"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ClusterHeader marks requests forwarded by a cluster member to the owner of an object. They're always served by
+// the node receiving them, even if its view of the cluster differs, so requests can't bounce between members.
+const ClusterHeader = "X-Gody-Cluster"
+
+// healthPath is where nodes answer the health checks of the other members
+const healthPath = "/_health"
+
+// Cluster makes several nodes act as one cache: every object is owned by one member, picked with consistent hashing,
+// and requests for objects owned by another member are proxied or redirected to it. Members failing their health
+// checks are skipped, their objects going to the next member on the ring until they're back.
+type Cluster struct {
+	self     string
+	ring     *hashring.Ring
+	redirect bool
+	timeout  time.Duration
+	client   *http.Client
+	proxy    http.RoundTripper
+	grp      *stop.Group
+
+	mu      sync.RWMutex
+	healthy map[string]bool
+}
+
+// MemberStatus describes a cluster member
+type MemberStatus struct {
+	URL     string `json:"url"`
+	Self    bool   `json:"self"`
+	Healthy bool   `json:"healthy"`
+}
+
+// NewCluster returns an initialized Cluster pointer. self is the URL the other members reach this node at, members
+// lists the URLs of all the members, redirect selects redirecting clients instead of proxying them and timeout limits
+// how long a member can take to answer health checks, accept proxied connections and start answering them.
+func NewCluster(self string, members []string, redirect bool, timeout time.Duration) *Cluster {
+	healthy := make(map[string]bool, len(members)+1)
+	for _, m := range members {
+		// members are assumed healthy until a check says otherwise
+		healthy[m] = true
+	}
+	if !healthy[self] {
+		members = append(members, self)
+		healthy[self] = true
+	}
+	return &Cluster{
+		self:     self,
+		ring:     hashring.New(members, hashring.DefaultReplicas),
+		redirect: redirect,
+		timeout:  timeout,
+		client:   &http.Client{Timeout: timeout},
+		proxy: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConnsPerHost:   100,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		grp:     stop.New(),
+		healthy: healthy,
+	}
+}
+
+// Start checks the health of the other members every interval until Shutdown is called
+func (cl *Cluster) Start(interval time.Duration) {
+	cl.grp.Add(1)
+	go func() {
+		defer cl.grp.Done()
+		for {
+			cl.checkMembers()
+			select {
+			case <-cl.grp.Ch():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// Shutdown stops the health checks
+func (cl *Cluster) Shutdown() {
+	cl.grp.StopAndWait()
+}
+
+func (cl *Cluster) checkMembers() {
+	wg := &sync.WaitGroup{}
+	for _, m := range cl.ring.Members() {
+		if m == cl.self {
+			continue
+		}
+		wg.Add(1)
+		go func(member string) {
+			defer wg.Done()
+			err := cl.checkMember(member)
+			if err != nil {
+				log.Debugf("cluster member %s failed its health check: %s", member, err.Error())
+			}
+			cl.setHealthy(member, err == nil)
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (cl *Cluster) checkMember(member string) error {
+	res, err := cl.client.Get(member + healthPath)
+	if err != nil {
+		return errors.Err(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return errors.Err("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (cl *Cluster) setHealthy(member string, healthy bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.healthy[member] != healthy {
+		log.Infof("cluster member %s is now healthy: %t", member, healthy)
+	}
+	cl.healthy[member] = healthy
+}
+
+// owner returns the healthy member owning the object
+func (cl *Cluster) owner(objectName string) string {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	for _, m := range cl.ring.Owners(objectName, len(cl.ring.Members())) {
+		if cl.healthy[m] {
+			return m
+		}
+	}
+	return cl.self
+}
+
+// Status returns the members of the cluster and their health
+func (cl *Cluster) Status() []MemberStatus {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	statuses := make([]MemberStatus, 0, len(cl.ring.Members()))
+	for _, m := range cl.ring.Members() {
+		statuses = append(statuses, MemberStatus{URL: m, Self: m == cl.self, Healthy: cl.healthy[m]})
+	}
+	return statuses
+}
+
+// forward sends the request to the owner of the object if it's another member. It returns false if the request has
+// to be served locally: this node owns the object, the request was already forwarded or the owner couldn't be reached.
+// Once the owner's response started being sent, a failure can't be recovered from: the proxy aborts the response with
+// http.ErrAbortHandler.
+func (cl *Cluster) forward(c *gin.Context, objectName string) bool {
+	if c.GetHeader(ClusterHeader) != "" {
+		return false
+	}
+	owner := cl.owner(objectName)
+	if owner == cl.self {
+		return false
+	}
+	target, err := url.Parse(owner)
+	if err != nil {
+		log.Errorf("invalid cluster member %s: %s", owner, err.Error())
+		return false
+	}
+	if cl.redirect {
+		c.Redirect(http.StatusTemporaryRedirect, owner+c.Request.URL.RequestURI())
+		return true
+	}
+	failed := false
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = cl.proxy
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Header.Set(ClusterHeader, "1")
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		if goerrors.Is(err, context.Canceled) {
+			// the client went away, there's no one left to serve
+			return
+		}
+		log.Warnf("error proxying to cluster member %s: %s", owner, err.Error())
+		if unreachable(err) {
+			cl.setHealthy(owner, false)
+		}
+		// the request can still be served locally as long as nothing was sent to the client
+		failed = !c.Writer.Written()
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+	return !failed
+}
+
+// unreachable returns true if the error means the member couldn't be reached or didn't answer in time, as opposed to
+// errors caused by the request itself
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	if goerrors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var netErr net.Error
+	return goerrors.As(err, &netErr) && netErr.Timeout()
+}
+
+// health answers the health checks of the other members
+func health(c *gin.Context) {
+	if c.Request.URL.Path == healthPath {
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Compression decides which responses are compressed: objects of the listed content types, between minSize and
+// maxSize bytes, sent to clients accepting gzip or brotli
+type Compression struct {
+	contentTypes     map[string]bool
+	minSize, maxSize int64
+}
+
+// NewCompression returns an initialized Compression pointer
+func NewCompression(contentTypes []string, minSize, maxSize int64) *Compression {
+	types := make(map[string]bool, len(contentTypes))
+	for _, t := range contentTypes {
+		types[mediaType(t)] = true
+	}
+	return &Compression{contentTypes: types, minSize: minSize, maxSize: maxSize}
+}
+
+// encoder is implemented by stores that can serve compressed copies of their objects
+type encoder interface {
+	GetEncoded(originalName, encoding string, meta *store.ObjectMeta, extra interface{}) (*store.ObjectStream, error)
+}
+
+// compressible returns true if the object can be compressed. Responses with such objects depend on the encodings
+// accepted by the client, so it also sets the Vary header.
+func (s *Server) compressible(c *gin.Context, objectName string, meta *store.ObjectMeta) bool {
+	if s.compression == nil {
+		return false
+	}
+	contentType, _ := objectHeaders(objectName, meta)
+	if !s.compression.contentTypes[mediaType(contentType)] {
+		return false
+	}
+	c.Header("Vary", "Accept-Encoding")
+	return true
+}
+
+// encode returns the compressed copy of the object if the client accepts one, closing the object, or the object itself
+// otherwise
+func (s *Server) encode(c *gin.Context, objectName string, stream *store.ObjectStream, extras store.MultiS3Extras) *store.ObjectStream {
+	if !s.compressible(c, objectName, stream.Meta) {
+		return stream
+	}
+	if stream.Size < s.compression.minSize || stream.Size > s.compression.maxSize {
+		return stream
+	}
+	encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+	enc, ok := s.store.(encoder)
+	if encoding == "" || !ok {
+		return stream
+	}
+	encoded, err := enc.GetEncoded(objectName, encoding, stream.Meta, extras)
+	if err != nil {
+		log.Errorf("error compressing %s, sending it uncompressed: %s", objectName, errors.FullTrace(err))
+		return stream
+	}
+	_ = stream.Close()
+	return encoded
+}
+
+// encodingPreference lists the supported encodings, preferred first when the client accepts them equally
+var encodingPreference = []string{store.EncodingBrotli, store.EncodingGzip}
+
+// negotiateEncoding returns the supported encoding the Accept-Encoding header prefers, "" if none is acceptable
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[name] = q
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range encodingPreference {
+		q, ok := qualities[encoding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// mediaType returns the content type without its parameters
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return t
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"deflate", ""},
+		{"gzip", store.EncodingGzip},
+		{"br", store.EncodingBrotli},
+		{"GZIP", store.EncodingGzip},
+		{"gzip, deflate, br", store.EncodingBrotli},
+		{"br, gzip", store.EncodingBrotli},
+		{"gzip;q=1.0, br;q=0.5", store.EncodingGzip},
+		{"gzip;q=0.5, br;q=0.5", store.EncodingBrotli},
+		{"gzip ; q=0.8, br ; q=0.9", store.EncodingBrotli},
+		{"gzip;q=0", ""},
+		{"br;q=0, gzip", store.EncodingGzip},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", store.EncodingBrotli},
+		{"*;q=0", ""},
+		{"br;q=0, *", store.EncodingGzip},
+		{"gzip, *;q=0", store.EncodingGzip},
+		{"*;q=0.5, gzip", store.EncodingGzip},
+		{"gzip;q=invalid", store.EncodingGzip},
+		{"identity;q=1, gzip;q=0.1", store.EncodingGzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			if got := negotiateEncoding(tt.acceptEncoding); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
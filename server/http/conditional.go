@@ -0,0 +1,85 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notModified returns true if the client already has the object according to the If-None-Match or, when there's
+// none, the If-Modified-Since header of the request (RFC 7232)
+func notModified(c *gin.Context, meta *store.ObjectMeta) bool {
+	if meta == nil {
+		return false
+	}
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		return etagListMatches(ifNoneMatch, meta.ETag, false)
+	}
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified := meta.Modified()
+	return !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+// rangeApplies returns true if the Range header of the request can be honored: there's no If-Range header or it
+// matches the object, so the parts the client has and the ones it's asking for belong to the same version
+func rangeApplies(c *gin.Context, meta *store.ObjectMeta) bool {
+	ifRange := c.GetHeader("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if meta == nil {
+		return false
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagListMatches(ifRange, meta.ETag, true)
+	}
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	modified := meta.Modified()
+	return !modified.IsZero() && modified.Truncate(time.Second).Equal(date)
+}
+
+// etagListMatches returns true if the ETag is in the comma separated list of ETags. Weak ETags never match with
+// strong comparison.
+func etagListMatches(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong {
+			if candidate == etag && !strings.HasPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// answerNotModified tells the client its copy of the object is still good, along with the validators and caching
+// headers it would have gotten with the object
+func answerNotModified(c *gin.Context, meta *store.ObjectMeta) {
+	for k, v := range meta.Headers() {
+		switch k {
+		case "ETag", "Last-Modified", "Cache-Control":
+			c.Header(k, v)
+		}
+	}
+	c.AbortWithStatus(http.StatusNotModified)
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEtagListMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		list   string
+		etag   string
+		strong bool
+		match  bool
+	}{
+		{"same", `"abc"`, `"abc"`, false, true},
+		{"different", `"abc"`, `"def"`, false, false},
+		{"in a list", `"abc", "def"`, `"def"`, false, true},
+		{"in a list without spaces", `"abc","def"`, `"def"`, false, true},
+		{"not in a list", `"abc", "def"`, `"ghi"`, false, false},
+		{"any", "*", `"abc"`, false, true},
+		{"any with spaces", " * ", `"abc"`, false, true},
+		{"no etag", `"abc"`, "", false, false},
+		{"any without etag", "*", "", false, false},
+		{"unquoted", "abc", `"abc"`, false, false},
+		{"weak candidate", `W/"abc"`, `"abc"`, false, true},
+		{"weak etag", `"abc"`, `W/"abc"`, false, true},
+		{"both weak", `W/"abc"`, `W/"abc"`, false, true},
+		{"strong", `"abc"`, `"abc"`, true, true},
+		{"strong in a list", `"abc", "def"`, `"def"`, true, true},
+		{"strong with a weak candidate", `W/"abc"`, `"abc"`, true, false},
+		{"strong with a weak etag", `"abc"`, `W/"abc"`, true, false},
+		{"strong with both weak", `W/"abc"`, `W/"abc"`, true, false},
+		{"strong with any", "*", `"abc"`, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagListMatches(tt.list, tt.etag, tt.strong); got != tt.match {
+				t.Errorf("expected %s matching %s (strong: %t) to be %t, got %t", tt.list, tt.etag, tt.strong, tt.match, got)
+			}
+		})
+	}
+}
+
+func TestRangeApplies(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 30, 15, 500, time.UTC)
+	meta := &store.ObjectMeta{ETag: `"abc"`, LastModified: modified}
+	tests := []struct {
+		name    string
+		ifRange string
+		meta    *store.ObjectMeta
+		applies bool
+	}{
+		{"no If-Range", "", meta, true},
+		{"no If-Range nor metadata", "", nil, true},
+		{"no metadata", `"abc"`, nil, false},
+		{"same etag", `"abc"`, meta, true},
+		{"different etag", `"def"`, meta, false},
+		{"weak etag", `W/"abc"`, meta, false},
+		{"weak etag of the object", `"abc"`, &store.ObjectMeta{ETag: `W/"abc"`}, false},
+		{"object without etag", `"abc"`, &store.ObjectMeta{LastModified: modified}, false},
+		{"same date", modified.Format(http.TimeFormat), meta, true},
+		{"earlier date", modified.Add(-time.Hour).Format(http.TimeFormat), meta, false},
+		{"later date", modified.Add(time.Hour).Format(http.TimeFormat), meta, false},
+		{"date of an object cached at that time", modified.Format(http.TimeFormat), &store.ObjectMeta{CachedAt: modified}, true},
+		{"date of an object without dates", modified.Format(http.TimeFormat), &store.ObjectMeta{ETag: `"abc"`}, false},
+		{"invalid", "yesterday", meta, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/object", nil)
+			if tt.ifRange != "" {
+				c.Request.Header.Set("If-Range", tt.ifRange)
+			}
+			if got := rangeApplies(c, tt.meta); got != tt.applies {
+				t.Errorf("expected %t, got %t", tt.applies, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+)
+
+// parseRange parses the value of a Range header. Only single ranges are supported, for anything else
+// false is returned and the whole object should be served, which is a legitimate answer to any Range request.
+func parseRange(header string) (store.ByteRange, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return store.ByteRange{}, false
+	}
+	start, end, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return store.ByteRange{}, false
+	}
+	if start == "" {
+		suffix, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || suffix < 0 {
+			return store.ByteRange{}, false
+		}
+		return store.ByteRange{Start: -1, End: suffix}, true
+	}
+	r := store.ByteRange{End: -1}
+	var err error
+	r.Start, err = strconv.ParseInt(start, 10, 64)
+	if err != nil || r.Start < 0 {
+		return store.ByteRange{}, false
+	}
+	if end != "" {
+		r.End, err = strconv.ParseInt(end, 10, 64)
+		if err != nil || r.End < r.Start {
+			return store.ByteRange{}, false
+		}
+	}
+	return r, true
+}
+
+// contentRange formats a range for the Content-Range header
+func contentRange(cr store.ContentRange) string {
+	return "bytes " + strconv.FormatInt(cr.Start, 10) + "-" + strconv.FormatInt(cr.End, 10) + "/" + strconv.FormatInt(cr.Size, 10)
+}
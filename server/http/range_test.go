@@ -0,0 +1,83 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected store.ByteRange
+		ok       bool
+	}{
+		{"bytes=0-99", store.ByteRange{Start: 0, End: 99}, true},
+		{"bytes=100-", store.ByteRange{Start: 100, End: -1}, true},
+		{"bytes=-500", store.ByteRange{Start: -1, End: 500}, true},
+		{"bytes=-0", store.ByteRange{Start: -1, End: 0}, true},
+		{"bytes=5-5", store.ByteRange{Start: 5, End: 5}, true},
+		{"bytes= 0-1", store.ByteRange{Start: 0, End: 1}, true},
+		{"", store.ByteRange{}, false},
+		{"items=0-1", store.ByteRange{}, false},
+		{"bytes=0-1,5-6", store.ByteRange{}, false},
+		{"bytes=5", store.ByteRange{}, false},
+		{"bytes=-", store.ByteRange{}, false},
+		{"bytes=9-5", store.ByteRange{}, false},
+		{"bytes=-1-5", store.ByteRange{}, false},
+		{"bytes=a-5", store.ByteRange{}, false},
+		{"bytes=0-b", store.ByteRange{}, false},
+		{"bytes=--5", store.ByteRange{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r, ok := parseRange(tt.header)
+			if ok != tt.ok || r != tt.expected {
+				t.Errorf("expected %+v, %t, got %+v, %t", tt.expected, tt.ok, r, ok)
+			}
+		})
+	}
+}
+
+func TestResolveParsedRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		size          int64
+		contentRange  string
+		length        int64
+		unsatisfiable bool
+	}{
+		{"bytes=0-99", 1000, "bytes 0-99/1000", 100, false},
+		{"bytes=0-", 1000, "bytes 0-999/1000", 1000, false},
+		{"bytes=900-2000", 1000, "bytes 900-999/1000", 100, false},
+		{"bytes=999-", 1000, "bytes 999-999/1000", 1, false},
+		{"bytes=1000-", 1000, "", 0, true},
+		{"bytes=-100", 1000, "bytes 900-999/1000", 100, false},
+		{"bytes=-5000", 1000, "bytes 0-999/1000", 1000, false},
+		{"bytes=-0", 1000, "", 0, true},
+		{"bytes=0-", 0, "", 0, true},
+		{"bytes=0-0", 0, "", 0, true},
+		{"bytes=-1", 0, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r, ok := parseRange(tt.header)
+			if !ok {
+				t.Fatalf("%s wasn't parsed", tt.header)
+			}
+			cr, err := r.Resolve(tt.size)
+			if tt.unsatisfiable {
+				if err == nil {
+					t.Errorf("expected %s of %d bytes to be unsatisfiable, got %s", tt.header, tt.size, contentRange(cr))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := contentRange(cr); got != tt.contentRange || cr.Length() != tt.length {
+				t.Errorf("expected %s (%d bytes), got %s (%d bytes)", tt.contentRange, tt.length, got, cr.Length())
+			}
+		})
+	}
+}
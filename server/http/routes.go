@@ -1,11 +1,12 @@
 package http
 
 import (
+	"mime"
 	"net/http"
-	"regexp"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/OdyseeTeam/gody-cdn/store"
 
@@ -17,104 +18,268 @@ import (
 )
 
 func (s *Server) getObject(c *gin.Context) {
+	if s.cluster != nil && s.cluster.forward(c, requestedObject(c)) {
+		return
+	}
 	waiter := &sync.WaitGroup{}
 	waiter.Add(1)
 	enqueue(&blobRequest{c: c, finished: waiter})
 	waiter.Wait()
 }
 
-var allowedOrigins = map[string]store.MultiS3Extras{
+// defaultOrigins are the origins requests can select before any is named in the configuration
+var defaultOrigins = map[string]store.MultiS3Extras{
 	"legacy": {S3Index: 0},
 	"wasabi": {S3Index: 1},
 }
 
+// namedOrigins returns the origins that can be selected by name: the default ones and those named in the
+// configuration. names holds the name of each origin in order, unnamed origins are left out.
+func namedOrigins(names []string) map[string]store.MultiS3Extras {
+	origins := make(map[string]store.MultiS3Extras, len(defaultOrigins)+len(names))
+	for name, extras := range defaultOrigins {
+		origins[name] = extras
+	}
+	for i, name := range names {
+		if name != "" {
+			origins[name] = store.MultiS3Extras{S3Index: i}
+		}
+	}
+	return origins
+}
+
 func (s *Server) HandleGetObject(c *gin.Context) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Errorf("Recovered from panic: %v", r)
 		}
 	}()
-	start := time.Now()
-	objectName := strings.ReplaceAll(c.Request.URL.Path, "/t-na/", "")
-	leadingSlashRegexp, err := regexp.Compile("^/")
-	if err != nil {
-		_ = c.Error(errors.Err(err))
-		c.String(http.StatusInternalServerError, err.Error())
-		return
-	}
-	objectName = leadingSlashRegexp.ReplaceAllString(objectName, "")
+	objectName := requestedObject(c)
 
-	unsafeOriginBucket := c.Query("origin")
-	extras := allowedOrigins["legacy"]
-	if unsafeOriginBucket != "" {
-		e, ok := allowedOrigins[unsafeOriginBucket]
-		if ok {
-			extras = e
-		} else {
-			c.AbortWithStatus(http.StatusNotFound)
-			return
-		}
+	extras, ok := s.originExtras(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
 	log.Debugf("object name: %s", objectName)
-	if s.missesCache.Has(objectName) {
-		serialized, err := shared.NewBlobTrace(time.Since(start), "http").Serialize()
-		c.Header("Via", serialized)
+	rng, ranged := parseRange(c.GetHeader("Range"))
+	parts := strings.Split(objectName, "/")
+	actualFileName := parts[len(parts)-1]
+	if ranged {
+		stream, cr, trace, err := store.GetRange(s.store, objectName, rng, extras)
 		if err != nil {
-			_ = c.Error(errors.Err(err))
-			c.String(http.StatusInternalServerError, err.Error())
+			if errors.Is(err, store.ErrRangeNotSatisfiable) {
+				s.setTrace(c, trace)
+				c.Header("Content-Range", "bytes */"+strconv.FormatInt(cr.Size, 10))
+				c.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			s.handleStoreError(c, trace, err)
 			return
 		}
-		c.AbortWithStatus(http.StatusNotFound)
-		return
-	}
-	blob, trace, err := s.store.Get(objectName, extras)
-	if err != nil {
-		serialized, serializeErr := trace.Serialize()
-		if serializeErr != nil {
-			_ = c.Error(errors.Prefix(serializeErr.Error(), err))
-			c.String(http.StatusInternalServerError, errors.Prefix(serializeErr.Error(), err).Error())
+		defer stream.Close()
+		if !s.setTrace(c, trace) {
 			return
 		}
-		c.Header("Via", serialized)
-
-		if errors.Is(err, store.ErrObjectNotFound) {
-			_ = s.missesCache.Set(objectName, true)
-			c.AbortWithStatus(http.StatusNotFound)
+		s.prefetch(objectName, extras)
+		if notModified(c, stream.Meta) {
+			answerNotModified(c, stream.Meta)
 			return
 		}
-		_ = c.Error(err)
-		c.String(http.StatusInternalServerError, err.Error())
+		if rangeApplies(c, stream.Meta) {
+			// ranges are always served from the uncompressed object
+			s.compressible(c, objectName, stream.Meta)
+			c.Header("Content-Disposition", "filename="+actualFileName)
+			c.Header("Accept-Ranges", "bytes")
+			c.Header("Content-Range", contentRange(cr))
+			contentType, headers := objectHeaders(objectName, stream.Meta)
+			c.DataFromReader(http.StatusPartialContent, cr.Length(), contentType, stream, headers)
+			return
+		}
+		// the client's copy is outdated, it gets the whole object instead
+		_ = stream.Close()
+	}
+	stream, trace, err := store.GetStream(s.store, objectName, extras)
+	if err != nil {
+		s.handleStoreError(c, trace, err)
+		return
+	}
+	defer func() { _ = stream.Close() }()
+	if !s.setTrace(c, trace) {
+		return
+	}
+	if !ranged {
+		s.prefetch(objectName, extras)
+	}
+	stream = s.encode(c, objectName, stream, extras)
+	if notModified(c, stream.Meta) {
+		answerNotModified(c, stream.Meta)
 		return
 	}
+	c.Header("Content-Disposition", "filename="+actualFileName)
+	c.Header("Accept-Ranges", "bytes")
+	contentType, headers := objectHeaders(objectName, stream.Meta)
+	c.DataFromReader(http.StatusOK, stream.Size, contentType, stream, headers)
+}
+
+// prefetch lets the prefetcher warm what's likely to be requested after the object. Requests from peers are left
+// out, the node the viewer is talking to takes care of them.
+func (s *Server) prefetch(objectName string, extras store.MultiS3Extras) {
+	if s.prefetcher != nil && !extras.FromPeer {
+		s.prefetcher.Requested(objectName, extras)
+	}
+}
+
+// mediaTypes are the content types of the media files commonly served, which the system's MIME database may not know
+// or get wrong (.ts is also TypeScript and Qt translations)
+var mediaTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".m4s":  "video/iso.segment",
+	".mpd":  "application/dash+xml",
+	".webm": "video/webm",
+	".vtt":  "text/vtt",
+	".aac":  "audio/aac",
+	".mp3":  "audio/mpeg",
+}
+
+// objectHeaders returns the content type of the object and the other headers the origin sent along with it. Objects
+// the origin didn't give a meaningful content type get one matching the extension of their name.
+func objectHeaders(objectName string, meta *store.ObjectMeta) (string, map[string]string) {
+	var headers map[string]string
+	if meta != nil {
+		headers = meta.Headers()
+	}
+	contentType := headers["Content-Type"]
+	delete(headers, "Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" || contentType == "binary/octet-stream" {
+		ext := strings.ToLower(path.Ext(objectName))
+		contentType = mediaTypes[ext]
+		if contentType == "" {
+			contentType = mime.TypeByExtension(ext)
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+	return contentType, headers
+}
+
+// setTrace sets the Via header to the serialized trace. It returns false if the request was aborted
+func (s *Server) setTrace(c *gin.Context, trace shared.BlobTrace) bool {
 	serialized, err := trace.Serialize()
 	if err != nil {
 		_ = c.Error(err)
 		c.String(http.StatusInternalServerError, err.Error())
+		return false
+	}
+	c.Header("Via", serialized)
+	return true
+}
+
+// handleStoreError answers the request with the error returned by the store
+func (s *Server) handleStoreError(c *gin.Context, trace shared.BlobTrace, err error) {
+	serialized, serializeErr := trace.Serialize()
+	if serializeErr != nil {
+		_ = c.Error(errors.Prefix(serializeErr.Error(), err))
+		c.String(http.StatusInternalServerError, errors.Prefix(serializeErr.Error(), err).Error())
 		return
 	}
-	parts := strings.Split(objectName, "/")
-	actualFileName := parts[len(parts)-1]
 	c.Header("Via", serialized)
-	c.Header("Content-Disposition", "filename="+actualFileName)
-	c.Data(http.StatusOK, "application/octet-stream", blob)
+
+	if errors.Is(err, store.ErrObjectNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrOriginUnavailable) {
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	_ = c.Error(err)
+	c.String(http.StatusInternalServerError, err.Error())
+}
+
+// requestedObject returns the name of the object requested by the path
+func requestedObject(c *gin.Context) string {
+	return strings.TrimPrefix(strings.ReplaceAll(c.Request.URL.Path, "/t-na/", ""), "/")
+}
+
+// originExtras returns the extra params selecting the origin requested through the origin query parameter, or false
+// if there's no such origin
+func (s *Server) originExtras(c *gin.Context) (store.MultiS3Extras, bool) {
+	extras := s.allowedOrigins["legacy"]
+	if unsafeOriginBucket := c.Query("origin"); unsafeOriginBucket != "" {
+		e, ok := s.allowedOrigins[unsafeOriginBucket]
+		if !ok {
+			return extras, false
+		}
+		extras = e
+	}
+	extras.FromPeer = c.GetHeader(store.PeerHeader) != ""
+	return extras, true
 }
 
+// cachedChecker is implemented by stores that can tell whether they have an object cached and what they know about it
+type cachedChecker interface {
+	HasCached(hash string, extra interface{}) (bool, error)
+	CachedMeta(hash string, extra interface{}) (*store.ObjectMeta, error)
+}
+
+// hasObject tells whether the object is available, along with its validators when it's cached. Peers are only told
+// about cached objects, so that they don't wait on the origin through this node.
 func (s *Server) hasObject(c *gin.Context) {
 	objectName := c.Query("object")
-	has, err := s.store.Has(objectName, nil)
+	extras, ok := s.originExtras(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	var has bool
+	var err error
+	if checker, ok := s.store.(cachedChecker); ok && extras.FromPeer {
+		has, err = checker.HasCached(objectName, extras)
+	} else {
+		has, err = s.store.Has(objectName, extras)
+	}
 	if err != nil {
 		_ = c.Error(err)
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	if has {
-		c.Status(http.StatusNoContent)
+	if !has {
+		c.Status(http.StatusNotFound)
 		return
 	}
-	c.Status(http.StatusNotFound)
+	if checker, ok := s.store.(cachedChecker); ok {
+		meta, err := checker.CachedMeta(objectName, extras)
+		if err != nil {
+			log.Errorf("error getting the metadata of %s: %s", objectName, errors.FullTrace(err))
+		}
+		if notModified(c, meta) {
+			answerNotModified(c, meta)
+			return
+		}
+		if meta != nil {
+			for k, v := range meta.Headers() {
+				c.Header(k, v)
+			}
+		}
+	}
+	c.Status(http.StatusNoContent)
 }
 
 func (s *Server) recoveryHandler(c *gin.Context, err interface{}) {
+	if err == http.ErrAbortHandler {
+		// the response was cut short on purpose (a proxied response broke off), let the server drop the connection
+		panic(err)
+	}
+	if c.Writer.Written() {
+		// the response already started, an error body would only corrupt it
+		return
+	}
 	c.JSON(500, gin.H{
 		"title": "Error",
 		"err":   err,
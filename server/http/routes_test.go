@@ -0,0 +1,90 @@
+package http
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OdyseeTeam/gody-cdn/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCorruptedObjectRefetched(t *testing.T) {
+	tests := []struct {
+		name    string
+		hotTier bool
+		// verifiedSize is the size up to which the cache verifies objects before returning them
+		verifiedSize int64
+	}{
+		{"found by the hot tier", true, 0},
+		{"found by the cache", false, 1 << 20},
+		{"found by the cache with the hot tier", true, 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := []byte("the content of the object, as the origin has it")
+			origin := store.NewMemoryStore(1<<20, 1<<20)
+			if err := origin.Put("video/segment.ts", content, nil); err != nil {
+				t.Fatal(err)
+			}
+			objectDir := t.TempDir()
+			disk, err := store.NewDiskStore(objectDir, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := store.NewSQLiteMetadata(filepath.Join(t.TempDir(), "metadata.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer meta.Close()
+			caching := store.NewCachingStore("test", origin, store.NewDBBackedStore(disk, meta).WithVerifiedSize(tt.verifiedSize))
+			if tt.hotTier {
+				caching.WithHotTier(store.NewMemoryStore(1<<20, 1<<20))
+			}
+			s := NewServer(caching, 1)
+
+			if code, body := get(s, "/video/segment.ts"); code != http.StatusOK || body != string(content) {
+				t.Fatalf("expected %q, got %d: %q", content, code, body)
+			}
+			corrupt(t, objectDir)
+			if code, body := get(s, "/video/segment.ts"); code != http.StatusOK || body != string(content) {
+				t.Fatalf("expected %q from the origin, got %d: %q", content, code, body)
+			}
+			// the object was cached again
+			if code, body := get(s, "/video/segment.ts"); code != http.StatusOK || body != string(content) {
+				t.Fatalf("expected %q, got %d: %q", content, code, body)
+			}
+		})
+	}
+}
+
+// get serves a GET request for the path, returning the status and the body of the response
+func get(s *Server, path string) (int, string) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, path, nil)
+	s.HandleGetObject(c)
+	return w.Code, w.Body.String()
+}
+
+// corrupt flips the first byte of every object cached in the directory
+func corrupt(t *testing.T, dir string) {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		object, err := os.ReadFile(path)
+		if err != nil || len(object) == 0 {
+			return err
+		}
+		object[0] ^= 0xff
+		return os.WriteFile(path, object, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
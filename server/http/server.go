@@ -5,11 +5,11 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/OdyseeTeam/gody-cdn/prewarm"
 	"github.com/OdyseeTeam/gody-cdn/store"
 
 	"github.com/lbryio/lbry.go/v2/extras/stop"
 
-	"github.com/bluele/gcache"
 	nice "github.com/ekyoung/gin-nice-recovery"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -20,7 +20,10 @@ type Server struct {
 	store              store.ObjectStore
 	grp                *stop.Group
 	concurrentRequests int
-	missesCache        gcache.Cache
+	allowedOrigins     map[string]store.MultiS3Extras
+	cluster            *Cluster
+	prefetcher         *prewarm.Prefetcher
+	compression        *Compression
 }
 
 // NewServer returns an initialized Server pointer.
@@ -29,10 +32,35 @@ func NewServer(store store.ObjectStore, requestQueueSize int) *Server {
 		store:              store,
 		grp:                stop.New(),
 		concurrentRequests: requestQueueSize,
-		missesCache:        gcache.New(2000).Expiration(5 * time.Minute).ARC().Build(),
+		allowedOrigins:     defaultOrigins,
 	}
 }
 
+// WithOrigins lets requests select the origins by the names they're given in the configuration, on top of the
+// default names. names holds the name of each origin in order, unnamed origins are left out.
+func (s *Server) WithOrigins(names []string) *Server {
+	s.allowedOrigins = namedOrigins(names)
+	return s
+}
+
+// WithCluster sends requests for objects owned by other cluster members to them
+func (s *Server) WithCluster(cluster *Cluster) *Server {
+	s.cluster = cluster
+	return s
+}
+
+// WithPrefetcher warms the segments following the requested ones
+func (s *Server) WithPrefetcher(prefetcher *prewarm.Prefetcher) *Server {
+	s.prefetcher = prefetcher
+	return s
+}
+
+// WithCompression compresses the responses the client accepts compressed, as decided by compression
+func (s *Server) WithCompression(compression *Compression) *Server {
+	s.compression = compression
+	return s
+}
+
 // Shutdown gracefully shuts down the peer server.
 func (s *Server) Shutdown() {
 	log.Debug("shutting down HTTP server")
@@ -47,6 +75,7 @@ func (s *Server) Start(address string) error {
 	router.Use(gin.Logger())
 	// Install nice.Recovery, passing the handler to call after recovery
 	router.Use(nice.Recovery(s.recoveryHandler))
+	router.Use(health)
 	router.GET("/*whatever", s.getObject)
 	router.HEAD("/*whatever", s.hasObject)
 	srv := &http.Server{
@@ -70,6 +99,10 @@ func (s *Server) Start(address string) error {
 
 func (s *Server) listenForShutdown(listener *http.Server) {
 	<-s.grp.Ch()
+	shutdownListener(listener)
+}
+
+func shutdownListener(listener *http.Server) {
 	// The context is used to inform the server it has 5 seconds to finish
 	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// AdmissionPolicy decides which of the objects missing from the cache are worth caching. Objects it rejects are
+// served straight from the origin, so objects requested once don't push popular ones out of the cache.
+type AdmissionPolicy interface {
+	// Admit records a request for the object, missing from the cache, and returns true if it should be cached
+	Admit(key string) bool
+	// Name is the name of the policy
+	Name() string
+}
+
+// AdmissionStats counts the decisions of the admission policy
+type AdmissionStats struct {
+	Policy   string `json:"policy"`
+	Admitted int64  `json:"admitted"`
+	Rejected int64  `json:"rejected"`
+}
+
+type admission struct {
+	policy             AdmissionPolicy
+	admitted, rejected atomic.Int64
+}
+
+// WithAdmission only caches the objects missing from the cache that the policy admits. Objects warmed ahead of
+// requests are always cached.
+func (c *CachingStore) WithAdmission(policy AdmissionPolicy) *CachingStore {
+	c.admission = &admission{policy: policy}
+	return c
+}
+
+// AdmissionStats returns how many objects the admission policy admitted and rejected. The policy is "none" when every
+// object is cached.
+func (c *CachingStore) AdmissionStats() AdmissionStats {
+	if c.admission == nil {
+		return AdmissionStats{Policy: "none"}
+	}
+	return AdmissionStats{
+		Policy:   c.admission.policy.Name(),
+		Admitted: c.admission.admitted.Load(),
+		Rejected: c.admission.rejected.Load(),
+	}
+}
+
+// admit tells whether an object missing from the cache should be cached
+func (c *CachingStore) admit(key string) bool {
+	if c.admission == nil {
+		return true
+	}
+	if c.admission.policy.Admit(key) {
+		c.admission.admitted.Add(1)
+		return true
+	}
+	c.admission.rejected.Add(1)
+	return false
+}
+
+// admitRange tells whether an object missing from the cache should be cached when a range of it is requested. Players
+// read objects with many range requests, so only the ones starting at the beginning of the object count as requests.
+func (c *CachingStore) admitRange(key string, r ByteRange) bool {
+	if c.admission == nil {
+		return true
+	}
+	return r.Start == 0 && c.admit(key)
+}
+
+// SecondHitPolicy admits objects the second time they're requested within the window. It remembers up to size
+// objects requested once.
+type SecondHitPolicy struct {
+	seen gcache.Cache
+}
+
+// NewSecondHitPolicy returns an initialized SecondHitPolicy pointer
+func NewSecondHitPolicy(size int, window time.Duration) *SecondHitPolicy {
+	return &SecondHitPolicy{seen: gcache.New(size).Expiration(window).LRU().Build()}
+}
+
+// Name is the name of the policy
+func (p *SecondHitPolicy) Name() string { return "second_hit" }
+
+// Admit returns true if the object was already requested within the window
+func (p *SecondHitPolicy) Admit(key string) bool {
+	if _, err := p.seen.Get(key); err == nil {
+		p.seen.Remove(key)
+		return true
+	}
+	_ = p.seen.Set(key, struct{}{})
+	return false
+}
+
+const (
+	// sketchDepth is how many counters each object has in the sketch, its estimate being the lowest
+	sketchDepth = 4
+	// maxCount is the highest value a counter of the sketch can reach
+	maxCount = 15
+)
+
+// TinyLFUPolicy admits objects once they've been requested minHits times recently, as estimated by a count-min sketch
+// sized for the given number of objects. Counts are halved after as many requests as there are objects, so that
+// popularity fades with time.
+type TinyLFUPolicy struct {
+	minHits int
+	seed    maphash.Seed
+
+	mu        sync.Mutex
+	counters  [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// NewTinyLFUPolicy returns an initialized TinyLFUPolicy pointer
+func NewTinyLFUPolicy(size, minHits int) *TinyLFUPolicy {
+	// keeping the counters mostly empty keeps objects from inheriting the counts of others
+	width := 1
+	for width < 4*size {
+		width *= 2
+	}
+	p := &TinyLFUPolicy{
+		minHits: min(minHits, maxCount),
+		seed:    maphash.MakeSeed(),
+		mask:    uint64(width - 1),
+		resetAt: size,
+	}
+	for i := range p.counters {
+		p.counters[i] = make([]uint8, width)
+	}
+	return p
+}
+
+// Name is the name of the policy
+func (p *TinyLFUPolicy) Name() string { return "tinylfu" }
+
+// Admit counts the request and returns true if the object was requested often enough
+func (p *TinyLFUPolicy) Admit(key string) bool {
+	h := maphash.String(p.seed, key)
+	// the indexes of the counters are derived from the two halves of the hash
+	h1, h2 := h&0xffffffff, h>>32
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	estimate := uint8(maxCount)
+	for i := range p.counters {
+		idx := (h1 + uint64(i)*h2) & p.mask
+		if p.counters[i][idx] < maxCount {
+			p.counters[i][idx]++
+		}
+		estimate = min(estimate, p.counters[i][idx])
+	}
+	p.additions++
+	if p.additions >= p.resetAt {
+		p.age()
+	}
+	return int(estimate) >= p.minHits
+}
+
+// age halves every counter. It must be called with the lock held.
+func (p *TinyLFUPolicy) age() {
+	for i := range p.counters {
+		for j := range p.counters[i] {
+			p.counters[i][j] /= 2
+		}
+	}
+	p.additions = 0
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSecondHitPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		expected []bool
+	}{
+		{"first hit", []string{"a"}, []bool{false}},
+		{"second hit", []string{"a", "a"}, []bool{false, true}},
+		{"forgotten once admitted", []string{"a", "a", "a", "a"}, []bool{false, true, false, true}},
+		{"keys counted separately", []string{"a", "b", "b", "a"}, []bool{false, false, true, true}},
+		{"evicted when full", []string{"a", "b", "c", "a"}, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewSecondHitPolicy(2, time.Hour)
+			for i, key := range tt.keys {
+				if got := p.Admit(key); got != tt.expected[i] {
+					t.Errorf("request %d for %s: expected %t, got %t", i, key, tt.expected[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestSecondHitPolicyWindow(t *testing.T) {
+	p := NewSecondHitPolicy(10, 10*time.Millisecond)
+	if p.Admit("a") {
+		t.Fatal("first hit admitted")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if p.Admit("a") {
+		t.Error("hit outside of the window admitted")
+	}
+	if !p.Admit("a") {
+		t.Error("second hit within the window rejected")
+	}
+}
+
+func TestTinyLFUPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		minHits int
+		// admittedAt is the request the object is first admitted at
+		admittedAt int
+	}{
+		{"one hit", 1, 1},
+		{"two hits", 2, 2},
+		{"five hits", 5, 5},
+		{"capped at the counter limit", 20, maxCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewTinyLFUPolicy(1000, tt.minHits)
+			for i := 1; i <= maxCount+1; i++ {
+				got := p.Admit("a")
+				if expected := i >= tt.admittedAt; got != expected {
+					t.Fatalf("request %d: expected %t, got %t", i, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTinyLFUPolicyOneHitWonders(t *testing.T) {
+	p := NewTinyLFUPolicy(1000, 2)
+	admitted := 0
+	for i := 0; i < 10000; i++ {
+		if p.Admit(fmt.Sprintf("object-%d", i)) {
+			admitted++
+		}
+	}
+	// a few objects can share all their counters with others, but only a few
+	if admitted > 100 {
+		t.Errorf("%d objects requested once were admitted", admitted)
+	}
+}
+
+func TestTinyLFUPolicyAging(t *testing.T) {
+	// counts are halved after every request, so the object never gets the 2 hits it needs
+	p := NewTinyLFUPolicy(1, 2)
+	for i := 1; i <= 10; i++ {
+		if p.Admit("a") {
+			t.Fatalf("request %d admitted, counts should have been halved", i)
+		}
+	}
+}
+
+func TestAdmissionStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		ranges   []ByteRange
+		admitted int64
+		rejected int64
+	}{
+		{"whole objects", []ByteRange{{0, -1}, {0, -1}, {0, -1}}, 2, 1},
+		{"ranges from the start", []ByteRange{{0, 99}, {0, 0}}, 1, 1},
+		{"ranges elsewhere don't count", []ByteRange{{0, -1}, {100, 199}, {200, -1}, {-1, 10}}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCachingStore("test", NewMemoryStore(1, 1), NewMemoryStore(1, 1))
+			if stats := c.AdmissionStats(); stats.Policy != "none" {
+				t.Errorf("expected no policy, got %s", stats.Policy)
+			}
+			c.WithAdmission(NewTinyLFUPolicy(1000, 2))
+			for _, r := range tt.ranges {
+				c.admitRange("a", r)
+			}
+			stats := c.AdmissionStats()
+			if stats.Policy != "tinylfu" || stats.Admitted != tt.admitted || stats.Rejected != tt.rejected {
+				t.Errorf("expected %d admitted and %d rejected by tinylfu, got %+v", tt.admitted, tt.rejected, stats)
+			}
+		})
+	}
+}
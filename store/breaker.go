@@ -0,0 +1,178 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// ErrOriginUnavailable is returned without contacting an origin while its circuit breaker is open
+var ErrOriginUnavailable = errors.Base("origin unavailable")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails every request without contacting the origin
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single probe through to find out whether the origin recovered
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerSettings configures a circuit breaker
+type BreakerSettings struct {
+	// FailureRate is the share of failed requests, between 0 and 1, that opens the breaker
+	FailureRate float64
+	// MinRequests is how many requests a window needs before the failure rate is taken into account
+	MinRequests int
+	// Window is how long requests are counted for before the counters are reset
+	Window time.Duration
+	// OpenFor is how long the breaker stays open before letting a probe through
+	OpenFor time.Duration
+	// SlowCall is the latency above which a successful request still counts as failed, 0 disables it
+	SlowCall time.Duration
+}
+
+// CircuitBreaker keeps track of the health of an origin and stops sending it requests while it's failing, so that
+// requests don't pile up waiting on a degraded origin
+type CircuitBreaker struct {
+	settings BreakerSettings
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	timed       int
+	latency     time.Duration
+	openedAt    time.Time
+	probing     bool
+}
+
+// BreakerTicket is handed out by Allow for every request it lets through, to be given back to Record along with the
+// outcome of the request
+type BreakerTicket struct {
+	// probe is set for the single request let through by a half-open breaker
+	probe bool
+}
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State BreakerState `json:"state"`
+	// Requests, Failures and AverageLatency are counted over the current window
+	Requests       int       `json:"requests"`
+	Failures       int       `json:"failures"`
+	AverageLatency string    `json:"average_latency"`
+	OpenedAt       time.Time `json:"opened_at,omitempty"`
+}
+
+// NewCircuitBreaker returns an initialized, closed, CircuitBreaker pointer.
+func NewCircuitBreaker(settings BreakerSettings) *CircuitBreaker {
+	return &CircuitBreaker{
+		settings:    settings,
+		state:       BreakerClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// Allow returns true if a request can be sent to the origin. Every allowed request must be followed by a call to Record
+// with the returned ticket.
+func (b *CircuitBreaker) Allow() (BreakerTicket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.settings.OpenFor {
+			return BreakerTicket{}, false
+		}
+		b.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return BreakerTicket{}, false
+		}
+		b.probing = true
+		return BreakerTicket{probe: true}, true
+	default:
+		return BreakerTicket{}, true
+	}
+}
+
+// Record counts the outcome of a request allowed by Allow. latency is how long the origin took to start answering, 0
+// for requests that weren't timed which are left out of the average latency. Only the outcome of the probe closes or
+// opens again a half-open breaker, requests let through before the breaker opened are ignored once it did.
+func (b *CircuitBreaker) Record(ticket BreakerTicket, failed bool, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.settings.SlowCall > 0 && latency > b.settings.SlowCall {
+		failed = true
+	}
+	if ticket.probe {
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.state = BreakerClosed
+			b.reset()
+		}
+		return
+	}
+	if b.state != BreakerClosed {
+		return
+	}
+	if time.Since(b.windowStart) > b.settings.Window {
+		b.reset()
+	}
+	b.requests++
+	if latency > 0 {
+		b.timed++
+		b.latency += latency
+	}
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.settings.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.settings.FailureRate {
+		b.open()
+	}
+}
+
+// Status returns a snapshot of the breaker
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{
+		State:    b.state,
+		Requests: b.requests,
+		Failures: b.failures,
+	}
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.settings.OpenFor {
+		// the next request will be a probe
+		status.State = BreakerHalfOpen
+	}
+	if b.timed > 0 {
+		status.AverageLatency = (b.latency / time.Duration(b.timed)).String()
+	}
+	if b.state != BreakerClosed {
+		status.OpenedAt = b.openedAt
+	}
+	return status
+}
+
+// open must be called with the lock held
+func (b *CircuitBreaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+}
+
+// reset must be called with the lock held
+func (b *CircuitBreaker) reset() {
+	b.windowStart = time.Now()
+	b.requests = 0
+	b.failures = 0
+	b.timed = 0
+	b.latency = 0
+}
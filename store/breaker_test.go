@@ -0,0 +1,139 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+type outcome struct {
+	failed  bool
+	latency time.Duration
+}
+
+var (
+	callOK     = outcome{false, 10 * time.Millisecond}
+	callFailed = outcome{true, 10 * time.Millisecond}
+	callSlow   = outcome{false, time.Second}
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []outcome
+		expected BreakerState
+	}{
+		{"no requests", nil, BreakerClosed},
+		{"successes", []outcome{callOK, callOK, callOK, callOK, callOK}, BreakerClosed},
+		{"too few requests to judge", []outcome{callFailed, callFailed, callFailed}, BreakerClosed},
+		{"failure rate reached", []outcome{callOK, callFailed, callOK, callFailed}, BreakerOpen},
+		{"failure rate not reached", []outcome{callOK, callFailed, callOK, callOK, callOK}, BreakerClosed},
+		{"failures after enough requests", []outcome{callOK, callOK, callOK, callOK, callFailed, callFailed, callFailed, callFailed}, BreakerOpen},
+		{"slow calls count as failures", []outcome{callOK, callSlow, callOK, callSlow}, BreakerOpen},
+		{"untimed requests aren't slow", []outcome{callOK, {false, 0}, callOK, {false, 0}}, BreakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(BreakerSettings{
+				FailureRate: 0.5,
+				MinRequests: 4,
+				Window:      time.Hour,
+				OpenFor:     time.Hour,
+				SlowCall:    100 * time.Millisecond,
+			})
+			for _, o := range tt.outcomes {
+				b.Record(BreakerTicket{}, o.failed, o.latency)
+			}
+			if state := b.Status().State; state != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, state)
+			}
+			if _, allowed := b.Allow(); allowed != (tt.expected == BreakerClosed) {
+				t.Errorf("expected a request to be allowed: %t, got %t", tt.expected == BreakerClosed, allowed)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		probe    outcome
+		expected BreakerState
+	}{
+		{"origin recovered", callOK, BreakerClosed},
+		{"origin still failing", callFailed, BreakerOpen},
+		{"origin still slow", callSlow, BreakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(BreakerSettings{
+				FailureRate: 0.5,
+				MinRequests: 1,
+				Window:      time.Hour,
+				OpenFor:     10 * time.Millisecond,
+				SlowCall:    100 * time.Millisecond,
+			})
+			b.Record(BreakerTicket{}, true, 0)
+			if _, allowed := b.Allow(); allowed {
+				t.Fatal("request allowed through the open breaker")
+			}
+			time.Sleep(20 * time.Millisecond)
+			if state := b.Status().State; state != BreakerHalfOpen {
+				t.Fatalf("expected %s, got %s", BreakerHalfOpen, state)
+			}
+			probe, allowed := b.Allow()
+			if !allowed {
+				t.Fatal("probe not allowed")
+			}
+			if _, allowed := b.Allow(); allowed {
+				t.Fatal("second request allowed while probing")
+			}
+			// requests let through before the breaker opened don't count
+			b.Record(BreakerTicket{}, !tt.probe.failed, 0)
+			if state := b.Status().State; state != BreakerHalfOpen {
+				t.Fatalf("expected %s after a late result, got %s", BreakerHalfOpen, state)
+			}
+			b.Record(probe, tt.probe.failed, tt.probe.latency)
+			if state := b.Status().State; state != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, state)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	b := NewCircuitBreaker(BreakerSettings{FailureRate: 0.5, MinRequests: 4, Window: 10 * time.Millisecond, OpenFor: time.Hour})
+	for i := 0; i < 3; i++ {
+		b.Record(BreakerTicket{}, true, 0)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// the failures of the previous window are forgotten
+	b.Record(BreakerTicket{}, true, 0)
+	if status := b.Status(); status.State != BreakerClosed || status.Requests != 1 || status.Failures != 1 {
+		t.Errorf("expected a closed breaker with 1 failed request, got %+v", status)
+	}
+}
+
+func TestCircuitBreakerAverageLatency(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		expected  string
+	}{
+		{"no requests", nil, ""},
+		{"untimed requests", []time.Duration{0, 0}, ""},
+		{"timed requests", []time.Duration{10 * time.Millisecond, 30 * time.Millisecond}, "20ms"},
+		{"untimed requests left out", []time.Duration{10 * time.Millisecond, 0, 30 * time.Millisecond, 0}, "20ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(BreakerSettings{FailureRate: 1, MinRequests: 100, Window: time.Hour, OpenFor: time.Hour})
+			for _, l := range tt.latencies {
+				b.Record(BreakerTicket{}, false, l)
+			}
+			status := b.Status()
+			if status.AverageLatency != tt.expected || status.Requests != len(tt.latencies) {
+				t.Errorf("expected %d requests averaging %q, got %+v", len(tt.latencies), tt.expected, status)
+			}
+		})
+	}
+}
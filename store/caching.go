@@ -8,6 +8,7 @@ import (
 	"github.com/lbryio/lbry.go/v2/extras/errors"
 	"github.com/lbryio/reflector.go/shared"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 // CachingStore combines two stores, typically a local and a remote store, to improve performance.
@@ -17,6 +18,16 @@ type CachingStore struct {
 	origin, cache ObjectStore
 	baseFuncs     *BaseFuncs
 	component     string
+	// fills makes sure an object is only streamed from the origin into the cache once at a time
+	fills *singleflight.Group
+	// chunking is set when large objects are cached in chunks
+	chunking *chunking
+	// hot is an optional in-memory tier in front of the cache
+	hot *MemoryStore
+	// freshness is set when cached objects have to be revalidated
+	freshness *freshness
+	// admission is set when only some of the missing objects are cached
+	admission *admission
 }
 
 // NewCachingStore makes a new caching disk store and returns a pointer to it.
@@ -25,6 +36,7 @@ func NewCachingStore(component string, origin, cache ObjectStore) *CachingStore
 		component: component,
 		origin:    WithSingleFlight(component, origin),
 		cache:     WithSingleFlight(component, cache),
+		fills:     new(singleflight.Group),
 	}
 }
 
@@ -34,6 +46,7 @@ func NewCachingStoreV2(component string, baseFuncs BaseFuncs, cache ObjectStore)
 		component: component,
 		baseFuncs: &baseFuncs,
 		cache:     WithSingleFlight(component, cache),
+		fills:     new(singleflight.Group),
 	}
 }
 
@@ -44,7 +57,7 @@ func (c *CachingStore) Name() string { return nameCaching }
 
 // Has checks the cache and then the origin for a hash. It returns true if either store has it.
 func (c *CachingStore) Has(hash string, extra interface{}) (bool, error) {
-	has, err := c.cache.Has(hash, extra)
+	has, err := c.HasCached(hash, extra)
 	if has || err != nil {
 		return has, err
 	}
@@ -54,60 +67,391 @@ func (c *CachingStore) Has(hash string, extra interface{}) (bool, error) {
 	return c.origin.Has(hash, extra)
 }
 
+// HasCached returns true if the object is cached, without asking the origin. Objects cached in chunks count as
+// cached as soon as their size is, even if some of their chunks aren't cached yet.
+func (c *CachingStore) HasCached(originalName string, extra interface{}) (bool, error) {
+	hashedName := hashName(originalName)
+	if c.hot != nil {
+		if has, _ := c.hot.Has(hashedName, extra); has {
+			return true, nil
+		}
+	}
+	if c.chunking != nil {
+		has, err := c.cache.Has(sizeKey(hashedName), extra)
+		if has || err != nil {
+			return has, err
+		}
+	}
+	return c.cache.Has(hashedName, extra)
+}
+
+// CachedMeta returns the metadata of the object if it's cached, without asking the origin. It's nil if the object
+// isn't cached or nothing is known about it.
+func (c *CachingStore) CachedMeta(originalName string, extra interface{}) (*ObjectMeta, error) {
+	hashedName := hashName(originalName)
+	if c.hot != nil {
+		if stream, _, err := c.hot.GetStream(hashedName, extra); err == nil {
+			return stream.Meta, nil
+		}
+	}
+	key := hashedName
+	size, chunked := c.knownChunked(hashedName)
+	if chunked {
+		// every chunk carries the metadata of the object
+		key = chunkKey(hashedName, 0)
+	}
+	// the metadata comes along with any stream, a single byte is as good as the whole object
+	stream, _, _, err := GetRange(c.cache, key, ByteRange{Start: 0, End: 0}, extra)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrRangeNotSatisfiable) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	_ = stream.Close()
+	if chunked {
+		return chunkedMeta(stream.Meta, size), nil
+	}
+	return stream.Meta, nil
+}
+
 // Get tries to get the object from the cache first, falling back to the origin. If the object comes
-// from the origin, it is also stored in the cache.
+// from the origin, it is also stored in the cache if the admission policy allows it.
 // the extra parameter is used in conjunction with the getter function passed in V2 so that extra data such as decryption keys can be passed down
 func (c *CachingStore) Get(originalName string, extra interface{}) ([]byte, shared.BlobTrace, error) {
-	h := sha1.New()
-	h.Write([]byte(originalName))
-	hashedName := hex.EncodeToString(h.Sum(nil))
+	// going through the stream keeps the metadata of the object and caches large objects in chunks
+	stream, trace, err := c.GetStream(originalName, extra)
+	if err != nil {
+		return nil, trace, err
+	}
+	defer stream.Close()
+	object, err := readAll(stream)
+	if err != nil {
+		return nil, trace, err
+	}
+	return object, trace, nil
+}
+
+// Warm caches the object if it isn't already, whatever the admission policy says: it's about to be requested. It's
+// streamed into the cache, in chunks if it's large enough, like it would be when requested.
+func (c *CachingStore) Warm(originalName string, extra interface{}) error {
+	hashedName := hashName(originalName)
+	has, err := c.cache.Has(hashedName, extra)
+	if err != nil || has {
+		return err
+	}
+	getter := func() (*ObjectStream, shared.BlobTrace, error) { return c.getOriginStream(originalName, extra) }
+	if c.chunking != nil {
+		size, first, _, err := c.objectSize(originalName, hashedName, extra)
+		if err != nil {
+			return err
+		}
+		if size > c.chunking.threshold {
+			for i := int64(0); i*c.chunking.chunkSize < size; i++ {
+				err = c.cacheChunk(originalName, hashedName, i, extra, first)
+				first = nil
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if whole := c.fullObject(originalName, first, size, extra); whole != nil {
+			defer whole.Close()
+			getter = fetchedGetter(whole)
+		}
+	}
+	_, err = c.fillWith(originalName, hashedName, extra, getter)
+	return err
+}
+
+// GetStream tries to stream the object from the cache first. On a miss the object is streamed from the origin into
+// the cache, once no matter how many requests are waiting for it, and then streamed to the caller from the cache.
+func (c *CachingStore) GetStream(originalName string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	hashedName := hashName(originalName)
 	start := time.Now()
-	object, trace, err := c.cache.Get(hashedName, extra)
+	if size, ok := c.knownChunked(hashedName); ok {
+		serve, err := c.freshChunked(originalName, hashedName, size, extra)
+		if err != nil {
+			return nil, shared.NewBlobTrace(time.Since(start), c.Name()), err
+		}
+		if serve {
+			stream, _, trace, err := c.getChunked(originalName, hashedName, size, ByteRange{Start: 0, End: -1}, extra, nil)
+			return stream, trace.Stack(time.Since(start), c.Name()), err
+		}
+		// the chunks were dropped, the object is fetched again like any other miss
+	}
+	if c.hot != nil {
+		stream, trace, err := c.hot.GetStream(hashedName, extra)
+		if err == nil {
+			serve, err := c.checkFreshness(originalName, hashedName, stream.Meta, stream.Size, false, extra)
+			if serve {
+				c.touchHot(hashedName)
+				return stream, trace.Stack(time.Since(start), c.Name()), nil
+			}
+			if err != nil {
+				return nil, trace.Stack(time.Since(start), c.Name()), err
+			}
+		}
+	}
+	stream, trace, err := GetStream(c.cache, hashedName, extra)
+	if err == nil {
+		stream, err = c.fresh(originalName, hashedName, stream, stream.Size, extra)
+	}
+	if err == nil {
+		stream, err = c.promote(hashedName, stream, extra)
+		if errors.Is(err, ErrChecksumMismatch) {
+			// the corrupted copy was taken out of the cache, the object is fetched again like any other miss
+			err = ErrObjectNotFound
+		}
+	}
 	if err == nil || !errors.Is(err, ErrObjectNotFound) {
-		return object, trace.Stack(time.Since(start), c.Name()), err
+		return stream, trace.Stack(time.Since(start), c.Name()), err
 	}
-	if c.baseFuncs != nil {
-		object, trace, err = c.baseFuncs.GetFunc(originalName, extra)
+	// the object may have been fetched already along with its size
+	var whole *ObjectStream
+	if c.chunking != nil {
+		var size int64
+		var first *ObjectStream
+		size, first, trace, err = c.objectSize(originalName, hashedName, extra)
+		if err != nil {
+			return nil, trace.Stack(time.Since(start), c.Name()), err
+		}
+		if size > c.chunking.threshold {
+			stream, _, trace, err := c.getChunked(originalName, hashedName, size, ByteRange{Start: 0, End: -1}, extra, first)
+			return stream, trace.Stack(time.Since(start), c.Name()), err
+		}
+		whole = c.fullObject(originalName, first, size, extra)
+	}
+	if !c.admit(hashedName) {
+		if whole != nil {
+			return whole, trace.Stack(time.Since(start), c.Name()), nil
+		}
+		stream, trace, err = c.getOriginStream(originalName, extra)
+		return stream, trace.Stack(time.Since(start), c.Name()), err
+	}
+	if whole != nil {
+		// the object is closed in case another fill of the object was already running and it wasn't used
+		defer whole.Close()
+		trace, err = c.fillWith(originalName, hashedName, extra, fetchedGetter(whole))
 	} else {
-		object, trace, err = c.origin.Get(originalName, extra)
+		trace, err = c.fill(originalName, hashedName, extra)
 	}
 	if err != nil {
 		return nil, trace.Stack(time.Since(start), c.Name()), err
 	}
-	// do not do this async unless you're prepared to deal with mayhem
-	err = c.cache.Put(hashedName, object, extra)
+	stream, _, err = GetStream(c.cache, hashedName, extra)
 	if err != nil {
-		log.Errorf("error saving object to underlying cache: %s", errors.FullTrace(err))
+		// the cache couldn't take the object, serve it straight from the origin instead
+		log.Errorf("error streaming freshly cached object: %s", errors.FullTrace(err))
+		stream, trace, err = c.getOriginStream(originalName, extra)
+		if err != nil {
+			return nil, trace.Stack(time.Since(start), c.Name()), err
+		}
 	}
-	return object, trace.Stack(time.Since(start), c.Name()), nil
+	return stream, trace.Stack(time.Since(start), c.Name()), nil
+}
+
+// GetRange serves a range of the object from the cache. On a miss the range is fetched straight from the origin
+// so the caller doesn't have to wait for the whole object, while the object is cached in the background.
+func (c *CachingStore) GetRange(originalName string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	hashedName := hashName(originalName)
+	start := time.Now()
+	if size, ok := c.knownChunked(hashedName); ok {
+		serve, err := c.freshChunked(originalName, hashedName, size, extra)
+		if err != nil {
+			return nil, ContentRange{}, shared.NewBlobTrace(time.Since(start), c.Name()), err
+		}
+		if serve {
+			stream, cr, trace, err := c.getChunked(originalName, hashedName, size, r, extra, nil)
+			return stream, cr, trace.Stack(time.Since(start), c.Name()), err
+		}
+		// the chunks were dropped, the object is fetched again like any other miss
+	}
+	if c.hot != nil {
+		stream, trace, err := c.hot.GetStream(hashedName, extra)
+		if err == nil {
+			serve, err := c.checkFreshness(originalName, hashedName, stream.Meta, stream.Size, false, extra)
+			if err != nil {
+				return nil, ContentRange{}, trace.Stack(time.Since(start), c.Name()), err
+			}
+			if serve {
+				c.touchHot(hashedName)
+				cr, err := sliceStream(stream, r)
+				return stream, cr, trace.Stack(time.Since(start), c.Name()), err
+			}
+		}
+	}
+	stream, cr, trace, err := GetRange(c.cache, hashedName, r, extra)
+	if err == nil {
+		stream, err = c.fresh(originalName, hashedName, stream, cr.Size, extra)
+	}
+	if err == nil || !errors.Is(err, ErrObjectNotFound) {
+		return stream, cr, trace.Stack(time.Since(start), c.Name()), err
+	}
+	// the object may have been fetched already along with its size
+	var whole *ObjectStream
+	if c.chunking != nil {
+		var size int64
+		var first *ObjectStream
+		size, first, trace, err = c.objectSize(originalName, hashedName, extra)
+		if err != nil {
+			return nil, cr, trace.Stack(time.Since(start), c.Name()), err
+		}
+		if size > c.chunking.threshold {
+			stream, cr, trace, err := c.getChunked(originalName, hashedName, size, r, extra, first)
+			return stream, cr, trace.Stack(time.Since(start), c.Name()), err
+		}
+		whole = wholeObject(first, size)
+	}
+	if c.admitRange(hashedName, r) {
+		go func() {
+			_, err := c.fill(originalName, hashedName, extra)
+			if err != nil && !errors.Is(err, ErrObjectNotFound) {
+				log.Errorf("error caching object in the background: %s", errors.FullTrace(err))
+			}
+		}()
+	}
+	if whole != nil {
+		cr, err = sliceStream(whole, r)
+		return whole, cr, trace.Stack(time.Since(start), c.Name()), err
+	}
+	stream, cr, trace, err = c.getOriginRange(originalName, r, extra)
+	return stream, cr, trace.Stack(time.Since(start), c.Name()), err
+}
+
+// fill streams the object from the origin into the cache. The returned error is only set if the origin failed,
+// errors while writing into the cache are logged and surface as a cache miss right after.
+func (c *CachingStore) fill(originalName, hashedName string, extra interface{}) (shared.BlobTrace, error) {
+	return c.fillWith(originalName, hashedName, extra, func() (*ObjectStream, shared.BlobTrace, error) {
+		return c.getOriginStream(originalName, extra)
+	})
+}
+
+// fillWith streams whatever the getter returns into the cache under the given key, once at a time per key. The key
+// is recorded as belonging to the object with the original name.
+func (c *CachingStore) fillWith(originalName, key string, extra interface{}, getter func() (*ObjectStream, shared.BlobTrace, error)) (shared.BlobTrace, error) {
+	t, err, _ := c.fills.Do(key, func() (interface{}, error) {
+		stream, trace, err := getter()
+		if err != nil {
+			return trace, err
+		}
+		defer stream.Close()
+		stream.Name = originalName
+		// do not do this async unless you're prepared to deal with mayhem
+		err = PutStream(c.cache, key, stream, extra)
+		if err != nil {
+			log.Errorf("error saving object to underlying cache: %s", errors.FullTrace(err))
+		}
+		return trace, nil
+	})
+	trace := t.(shared.BlobTrace)
+	// the trace is shared by all the callers waiting on the same fill, don't let them append to the same slice
+	return shared.BlobTrace{Stacks: append([]shared.BlobStack(nil), trace.Stacks...)}, err
+}
+
+// fetchedGetter returns a getter handing out a stream that was already fetched from the origin
+func fetchedGetter(stream *ObjectStream) func() (*ObjectStream, shared.BlobTrace, error) {
+	return func() (*ObjectStream, shared.BlobTrace, error) {
+		return stream, shared.BlobTrace{}, nil
+	}
+}
+
+func (c *CachingStore) getOriginStream(originalName string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	if c.baseFuncs != nil {
+		object, trace, err := c.baseFuncs.GetFunc(originalName, extra)
+		if err != nil {
+			return nil, trace, err
+		}
+		return NewObjectStream(object), trace, nil
+	}
+	return GetStream(c.origin, originalName, extra)
+}
+
+func (c *CachingStore) getOriginRange(originalName string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	if c.baseFuncs != nil {
+		stream, trace, err := c.getOriginStream(originalName, extra)
+		if err != nil {
+			return nil, ContentRange{}, trace, err
+		}
+		cr, err := sliceStream(stream, r)
+		if err != nil {
+			return nil, cr, trace, err
+		}
+		return stream, cr, trace, nil
+	}
+	return GetRange(c.origin, originalName, r, extra)
 }
 
 // Put stores the object in the origin and the cache
-func (c *CachingStore) Put(hash string, object []byte, extra interface{}) error {
+func (c *CachingStore) Put(originalName string, object []byte, extra interface{}) error {
 	var err error
 	if c.baseFuncs != nil {
-		err = c.baseFuncs.PutFunc(hash, object, extra)
+		err = c.baseFuncs.PutFunc(originalName, object, extra)
+	} else {
+		err = c.origin.Put(originalName, object, extra)
+	}
+	if err != nil {
+		return err
+	}
+	err = c.dropCached(hashName(originalName))
+	if err != nil {
+		return err
+	}
+	stream := NewObjectStream(object)
+	stream.Name = originalName
+	return PutStream(c.cache, hashName(originalName), stream, extra)
+}
+
+// PutStream stores the object in the cache and from there in the origin. If the origin refuses the object
+// it's removed from the cache again.
+func (c *CachingStore) PutStream(originalName string, object *ObjectStream, extra interface{}) error {
+	hashedName := hashName(originalName)
+	err := c.dropCached(hashedName)
+	if err != nil {
+		return err
+	}
+	object.Name = originalName
+	err = PutStream(c.cache, hashedName, object, extra)
+	if err != nil {
+		return err
+	}
+	cached, _, err := GetStream(c.cache, hashedName, extra)
+	if err != nil {
+		return err
+	}
+	defer cached.Close()
+	if c.baseFuncs != nil {
+		var buf []byte
+		buf, err = readAll(cached)
+		if err == nil {
+			err = c.baseFuncs.PutFunc(originalName, buf, extra)
+		}
 	} else {
-		err = c.origin.Put(hash, object, extra)
+		err = PutStream(c.origin, originalName, cached, extra)
 	}
 	if err != nil {
+		e2 := c.cache.Delete(hashedName, extra)
+		if e2 != nil {
+			log.Errorf("error removing object from cache after failed origin upload: %s", errors.FullTrace(e2))
+		}
 		return err
 	}
-	return c.cache.Put(hash, object, extra)
+	return nil
 }
 
 // Delete deletes the object from the origin and the cache
-func (c *CachingStore) Delete(hash string, extra interface{}) error {
+func (c *CachingStore) Delete(originalName string, extra interface{}) error {
 	var err error
 	if c.baseFuncs != nil {
-		err = c.baseFuncs.DelFunc(hash, extra)
+		err = c.baseFuncs.DelFunc(originalName, extra)
 	} else {
-		err = c.origin.Delete(hash, extra)
+		err = c.origin.Delete(originalName, extra)
 	}
 	if err != nil {
 		return err
 	}
-	return c.cache.Delete(hash, extra)
+	return c.dropCached(hashName(originalName))
 }
 
 // Shutdown shuts down the store gracefully
@@ -117,3 +461,10 @@ func (c *CachingStore) Shutdown() {
 	}
 	c.cache.Shutdown()
 }
+
+// hashName returns the name under which an object is kept in the cache
+func hashName(originalName string) string {
+	h := sha1.New()
+	h.Write([]byte(originalName))
+	return hex.EncodeToString(h.Sum(nil))
+}
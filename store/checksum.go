@@ -0,0 +1,131 @@
+package store
+
+import (
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Quarantiner is implemented by stores that can set a corrupted object aside for inspection instead of deleting it
+type Quarantiner interface {
+	// Quarantine moves the object out of the store
+	Quarantine(hash string) error
+}
+
+// ErrChecksumMismatch is returned when the content of a cached object doesn't match the checksum taken when it was cached
+var ErrChecksumMismatch = errors.Base("checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// newChecksum returns the hash used to checksum cached objects. CRC-32C is hardware accelerated and
+// good enough to catch torn writes and bit rot.
+func newChecksum() hash.Hash32 {
+	return crc32.New(castagnoli)
+}
+
+func checksum(object []byte) uint32 {
+	return crc32.Checksum(object, castagnoli)
+}
+
+// verifyingReader checksums an object as it's being read and calls onMismatch if the checksum is off
+// once the whole object went through. Objects that aren't read in full aren't verified.
+type verifyingReader struct {
+	io.ReadCloser
+	hasher     hash.Hash32
+	expected   uint32
+	remaining  int64
+	onMismatch func()
+}
+
+func newVerifyingReader(r io.ReadCloser, size int64, expected uint32, onMismatch func()) *verifyingReader {
+	return &verifyingReader{
+		ReadCloser: r,
+		hasher:     newChecksum(),
+		expected:   expected,
+		remaining:  size,
+		onMismatch: onMismatch,
+	}
+}
+
+// Read reads from the underlying reader and fails the read that completes the object if the checksum doesn't match
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	if v.remaining <= 0 {
+		return n, err
+	}
+	_, _ = v.hasher.Write(p[:n])
+	v.remaining -= int64(n)
+	if v.remaining <= 0 && v.hasher.Sum32() != v.expected {
+		v.onMismatch()
+		return n, errors.Err(ErrChecksumMismatch)
+	}
+	return n, err
+}
+
+// quarantine takes a corrupted object out of the cache so that it gets fetched again from the origin
+func (d *DBBackedStore) quarantine(hash string, extra interface{}) {
+	log.Errorf("object %s is corrupted, taking it out of the cache", hash)
+	var err error
+	if q, ok := d.objectsStore.(Quarantiner); ok {
+		err = q.Quarantine(hash)
+	} else {
+		err = d.objectsStore.Delete(hash, extra)
+	}
+	if err != nil {
+		log.Errorf("error quarantining object %s: %s", hash, errors.FullTrace(err))
+	}
+	err = d.meta.Delete(hash)
+	if err != nil {
+		log.Errorf("error while deleting object from db: %s", errors.FullTrace(err))
+	}
+}
+
+// verified reads the object in memory and checks it against its checksum. A corrupted object is quarantined and
+// reported missing so that it gets fetched again.
+func (d *DBBackedStore) verified(hash string, stream *ObjectStream, expected uint32, extra interface{}) (*ObjectStream, error) {
+	defer stream.Close()
+	object, err := readAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	if checksum(object) != expected {
+		d.quarantine(hash, extra)
+		return nil, ErrObjectNotFound
+	}
+	verified := NewObjectStream(object)
+	verified.Meta = stream.Meta
+	return verified, nil
+}
+
+// Verify reads the object in full and checks it against its checksum, quarantining it if it's corrupted.
+// It returns how many bytes were read. Objects without a checksum are skipped.
+func (d *DBBackedStore) Verify(hash string) (int64, error) {
+	record, err := d.lookup(hash)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if record.Checksum == nil {
+		return 0, nil
+	}
+	stream, _, err := GetStream(d.objectsStore, hash, nil)
+	if err != nil {
+		return 0, d.checkMissing(hash, nil, err)
+	}
+	defer stream.Close()
+	hasher := newChecksum()
+	read, err := io.Copy(hasher, stream)
+	if err != nil {
+		return read, errors.Err(err)
+	}
+	if read != stream.Size || hasher.Sum32() != *record.Checksum {
+		d.quarantine(hash, nil)
+		return read, errors.Err(ErrChecksumMismatch)
+	}
+	return read, nil
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+	log "github.com/sirupsen/logrus"
+)
+
+// chunking holds the settings for caching large objects in chunks
+type chunking struct {
+	// objects bigger than threshold are cached in chunks
+	threshold int64
+	chunkSize int64
+	// sizes remembers the size of recently requested objects so that it doesn't have to be looked up every time
+	sizes gcache.Cache
+}
+
+// WithChunking makes the store cache objects bigger than threshold as separate chunks of chunkSize bytes.
+// Chunks are fetched from the origin with range requests only when they're needed, and each one is stored,
+// tracked and evicted as an object of its own, so only the parts of an object that are actually read use space.
+// The origin should support ranges (see RangeObjectStore) or whole objects will be downloaded for every chunk.
+func (c *CachingStore) WithChunking(threshold, chunkSize int64) *CachingStore {
+	c.chunking = &chunking{
+		threshold: threshold,
+		chunkSize: chunkSize,
+		sizes:     gcache.New(10000).LRU().Build(),
+	}
+	return c
+}
+
+// chunkKey is the name under which a chunk is cached
+func chunkKey(hashedName string, index int64) string {
+	return hashedName + "-" + strconv.FormatInt(index, 10)
+}
+
+// sizeKey is the name under which the size of a chunked object is cached
+func sizeKey(hashedName string) string {
+	return hashedName + "-size"
+}
+
+// knownChunked returns the size of the object if it's known to be cached in chunks
+func (c *CachingStore) knownChunked(hashedName string) (int64, bool) {
+	if c.chunking == nil {
+		return 0, false
+	}
+	s, err := c.chunking.sizes.Get(hashedName)
+	if err != nil {
+		return 0, false
+	}
+	size := s.(int64)
+	return size, size > c.chunking.threshold
+}
+
+// objectSize finds out how big the object is, first from the cache and then by asking the origin for the first chunk.
+// In that case the stream of the first chunk is returned too, so that it isn't requested twice: the caller must close
+// it. It's the whole object if the object fits in a chunk.
+func (c *CachingStore) objectSize(originalName, hashedName string, extra interface{}) (int64, *ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	if s, err := c.chunking.sizes.Get(hashedName); err == nil {
+		return s.(int64), nil, shared.NewBlobTrace(time.Since(start), c.Name()), nil
+	}
+	cached, trace, err := c.cache.Get(sizeKey(hashedName), extra)
+	if err == nil {
+		size, err := strconv.ParseInt(string(cached), 10, 64)
+		if err != nil {
+			log.Errorf("invalid size cached for %s: %s", hashedName, err.Error())
+		} else if serve, err := c.freshChunked(originalName, hashedName, size, extra); err != nil {
+			return 0, nil, trace, err
+		} else if serve {
+			_ = c.chunking.sizes.Set(hashedName, size)
+			return size, nil, trace, nil
+		}
+		// otherwise the size is asked from the origin again, the chunks were dropped if they went stale
+	}
+
+	first, cr, trace, err := c.getOriginRange(originalName, c.chunkRange(0), extra)
+	if err != nil && !errors.Is(err, ErrRangeNotSatisfiable) {
+		return 0, nil, trace, err
+	}
+	_ = c.chunking.sizes.Set(hashedName, cr.Size)
+	if cr.Size > c.chunking.threshold {
+		stream := NewObjectStream([]byte(strconv.FormatInt(cr.Size, 10)))
+		stream.Name = originalName
+		err = PutStream(c.cache, sizeKey(hashedName), stream, extra)
+		if err != nil {
+			log.Errorf("error saving object size to underlying cache: %s", errors.FullTrace(err))
+		}
+	}
+	return cr.Size, first, trace, nil
+}
+
+// wholeObject returns the first chunk of an object of the given size if it's the whole object. Otherwise the chunk, if
+// any, is closed and nil is returned.
+func wholeObject(first *ObjectStream, size int64) *ObjectStream {
+	if first == nil || first.Size == size {
+		return first
+	}
+	_ = first.Close()
+	return nil
+}
+
+// fullObject returns a stream of the whole object of the given size from its first chunk, fetching the rest of it from
+// the origin if the object doesn't fit in a chunk. It returns nil if there's no first chunk or the rest can't be
+// fetched, in which case the object has to be fetched whole.
+func (c *CachingStore) fullObject(originalName string, first *ObjectStream, size int64, extra interface{}) *ObjectStream {
+	if whole := wholeObject(first, size); whole != nil || first == nil {
+		return whole
+	}
+	rest, cr, _, err := c.getOriginRange(originalName, ByteRange{Start: first.Size, End: -1}, extra)
+	if err != nil || cr.Start != first.Size || (first.Meta != nil && !sameVersion(first.Meta, size, rest.Meta, cr.Size)) {
+		// the object changed in between, or is gone
+		if err == nil {
+			_ = rest.Close()
+		}
+		_ = first.Close()
+		return nil
+	}
+	return &ObjectStream{
+		ReadCloser: &joinedReader{Reader: io.MultiReader(first, rest), first: first, second: rest},
+		Size:       size,
+		Meta:       first.Meta,
+	}
+}
+
+// joinedReader reads one stream after the other, closing both when it's closed
+type joinedReader struct {
+	io.Reader
+	first, second io.Closer
+}
+
+// Close closes both streams
+func (j *joinedReader) Close() error {
+	err := j.first.Close()
+	if e := j.second.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// getChunked streams the requested range of an object of the given size, chunk by chunk. first is the first chunk if it
+// was just fetched from the origin, nil otherwise.
+func (c *CachingStore) getChunked(originalName, hashedName string, size int64, r ByteRange, extra interface{}, first *ObjectStream) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	cr, err := r.Resolve(size)
+	if first != nil && (err != nil || cr.Start >= c.chunking.chunkSize) {
+		// the range doesn't start in the first chunk
+		_ = first.Close()
+		first = nil
+	}
+	if err != nil {
+		return nil, cr, shared.NewBlobTrace(0, c.Name()), err
+	}
+	reader := &chunkReader{
+		store:        c,
+		originalName: originalName,
+		hashedName:   hashedName,
+		extra:        extra,
+		first:        first,
+		next:         cr.Start / c.chunking.chunkSize,
+		skip:         cr.Start % c.chunking.chunkSize,
+		remaining:    cr.Length(),
+	}
+	// open the first chunk right away so that errors surface before anything is sent to the client
+	trace, err := reader.advance()
+	if err != nil {
+		return nil, cr, trace, err
+	}
+	// every chunk carries the metadata of the object
+	return &ObjectStream{ReadCloser: reader, Size: cr.Length(), Meta: chunkedMeta(reader.current.Meta, size)}, cr, trace, nil
+}
+
+// chunkedMeta returns the metadata of a chunked object of the given size from the metadata of one of its chunks. An
+// ETag derived from the checksum of a chunk would change from one chunk to the other, so objects the origin didn't
+// give an ETag get one derived from their size and modification time instead, or none if that time isn't known.
+func chunkedMeta(meta *ObjectMeta, size int64) *ObjectMeta {
+	if meta == nil || !meta.checksumETag {
+		return meta
+	}
+	m := *meta
+	m.ETag = ""
+	m.checksumETag = false
+	if !m.LastModified.IsZero() {
+		m.ETag = fmt.Sprintf(`"%x-%x"`, size, m.LastModified.Unix())
+	}
+	return m.orNil()
+}
+
+// getChunk returns a stream of the chunk at the given index, caching it first if needed. fetched is the chunk if it was
+// already fetched from the origin, nil otherwise.
+func (c *CachingStore) getChunk(originalName, hashedName string, index int64, extra interface{}, fetched *ObjectStream) (*ObjectStream, shared.BlobTrace, error) {
+	key := chunkKey(hashedName, index)
+	stream, trace, err := GetStream(c.cache, key, extra)
+	if err == nil || !errors.Is(err, ErrObjectNotFound) {
+		if fetched != nil {
+			_ = fetched.Close()
+		}
+		return stream, trace, err
+	}
+	getter := c.chunkGetter(originalName, index, extra)
+	if !c.admit(key) {
+		if fetched != nil {
+			return fetched, shared.NewBlobTrace(0, c.Name()), nil
+		}
+		return getter()
+	}
+	fill := getter
+	if fetched != nil {
+		// the chunk is closed in case another fill of the chunk was already running and it wasn't used
+		defer fetched.Close()
+		fill = fetchedGetter(fetched)
+	}
+	trace, err = c.fillWith(originalName, key, extra, fill)
+	if err != nil {
+		return nil, trace, err
+	}
+	stream, _, err = GetStream(c.cache, key, extra)
+	if err != nil {
+		log.Errorf("error streaming freshly cached chunk: %s", errors.FullTrace(err))
+		return getter()
+	}
+	return stream, trace, nil
+}
+
+// cacheChunk fetches the chunk at the given index into the cache if it isn't already. fetched is the chunk if it was
+// already fetched from the origin, nil otherwise.
+func (c *CachingStore) cacheChunk(originalName, hashedName string, index int64, extra interface{}, fetched *ObjectStream) error {
+	getter := c.chunkGetter(originalName, index, extra)
+	if fetched != nil {
+		defer fetched.Close()
+		getter = fetchedGetter(fetched)
+	}
+	key := chunkKey(hashedName, index)
+	has, err := c.cache.Has(key, extra)
+	if err != nil || has {
+		return err
+	}
+	_, err = c.fillWith(originalName, key, extra, getter)
+	return err
+}
+
+// chunkRange is the range of the object covered by the chunk at the given index
+func (c *CachingStore) chunkRange(index int64) ByteRange {
+	return ByteRange{Start: index * c.chunking.chunkSize, End: (index+1)*c.chunking.chunkSize - 1}
+}
+
+// chunkGetter returns a function fetching the chunk at the given index from the origin
+func (c *CachingStore) chunkGetter(originalName string, index int64, extra interface{}) func() (*ObjectStream, shared.BlobTrace, error) {
+	r := c.chunkRange(index)
+	return func() (*ObjectStream, shared.BlobTrace, error) {
+		stream, _, trace, err := c.getOriginRange(originalName, r, extra)
+		return stream, trace, err
+	}
+}
+
+// chunkReader reads a range of a chunked object, opening chunks one after the other as they're needed
+type chunkReader struct {
+	store        *CachingStore
+	originalName string
+	hashedName   string
+	extra        interface{}
+
+	// first is the first chunk if it was already fetched from the origin
+	first   *ObjectStream
+	current *ObjectStream
+	// left is how many bytes are expected from the current chunk
+	left int64
+	// next is the index of the chunk to open after the current one
+	next int64
+	// skip is how many bytes to skip at the beginning of the next chunk
+	skip int64
+	// remaining is how many bytes are left to read
+	remaining int64
+}
+
+func (r *chunkReader) advance() (shared.BlobTrace, error) {
+	fetched := r.first
+	r.first = nil
+	stream, trace, err := r.store.getChunk(r.originalName, r.hashedName, r.next, r.extra, fetched)
+	if err != nil {
+		return trace, err
+	}
+	if r.skip > 0 {
+		_, err = sliceStream(stream, ByteRange{Start: r.skip, End: -1})
+		if err != nil {
+			return trace, err
+		}
+	}
+	r.current = stream
+	r.left = r.store.chunking.chunkSize - r.skip
+	if r.left > r.remaining {
+		r.left = r.remaining
+	}
+	r.next++
+	r.skip = 0
+	return trace, nil
+}
+
+// Read reads from the current chunk, moving on to the next one when it's exhausted
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if r.current == nil {
+			_, err := r.advance()
+			if err != nil {
+				return 0, err
+			}
+		}
+		if int64(len(p)) > r.left {
+			p = p[:r.left]
+		}
+		n, err := r.current.Read(p)
+		r.remaining -= int64(n)
+		r.left -= int64(n)
+		if r.left == 0 || err == io.EOF {
+			if r.left > 0 {
+				// a chunk shorter than it should be would shift everything after it
+				return n, errors.Err(io.ErrUnexpectedEOF)
+			}
+			_ = r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close closes the chunk being read
+func (r *chunkReader) Close() error {
+	if r.first != nil {
+		_ = r.first.Close()
+		r.first = nil
+	}
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
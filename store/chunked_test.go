@@ -0,0 +1,145 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+const chunkedObject = "0123456789abcdefghij"
+
+// newChunkedStore returns a store caching objects bigger than 10 bytes in chunks of 4 bytes, its origin holding a
+// 20 bytes object named "object"
+func newChunkedStore(t *testing.T) (*CachingStore, *MemoryStore) {
+	origin := NewMemoryStore(1<<20, 1<<20)
+	if err := origin.Put("object", []byte(chunkedObject), nil); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewMemoryStore(1<<20, 1<<20)
+	return NewCachingStore("test", origin, cache).WithChunking(10, 4), cache
+}
+
+func TestChunkedGetRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        ByteRange
+		expected ContentRange
+	}{
+		{"whole object", ByteRange{Start: 0, End: -1}, ContentRange{Start: 0, End: 19, Size: 20}},
+		{"first chunk", ByteRange{Start: 0, End: 3}, ContentRange{Start: 0, End: 3, Size: 20}},
+		{"single byte", ByteRange{Start: 5, End: 5}, ContentRange{Start: 5, End: 5, Size: 20}},
+		{"within a chunk", ByteRange{Start: 5, End: 6}, ContentRange{Start: 5, End: 6, Size: 20}},
+		{"across chunks", ByteRange{Start: 3, End: 12}, ContentRange{Start: 3, End: 12, Size: 20}},
+		{"chunk boundaries", ByteRange{Start: 4, End: 11}, ContentRange{Start: 4, End: 11, Size: 20}},
+		{"open ended", ByteRange{Start: 9, End: -1}, ContentRange{Start: 9, End: 19, Size: 20}},
+		{"past the end", ByteRange{Start: 15, End: 100}, ContentRange{Start: 15, End: 19, Size: 20}},
+		{"last byte", ByteRange{Start: 19, End: -1}, ContentRange{Start: 19, End: 19, Size: 20}},
+		{"suffix", ByteRange{Start: -1, End: 6}, ContentRange{Start: 14, End: 19, Size: 20}},
+		{"suffix bigger than the object", ByteRange{Start: -1, End: 50}, ContentRange{Start: 0, End: 19, Size: 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newChunkedStore(t)
+			// the first read fetches the chunks from the origin, the second one reads them from the cache
+			for _, read := range []string{"origin", "cache"} {
+				stream, cr, _, err := c.GetRange("object", tt.r, nil)
+				if err != nil {
+					t.Fatalf("%s: %s", read, err)
+				}
+				got, err := io.ReadAll(stream)
+				_ = stream.Close()
+				if err != nil {
+					t.Fatalf("%s: %s", read, err)
+				}
+				expected := chunkedObject[tt.expected.Start : tt.expected.End+1]
+				if cr != tt.expected || string(got) != expected || stream.Size != int64(len(expected)) {
+					t.Errorf("%s: expected %q in %+v, got %q (%d bytes) in %+v", read, expected, tt.expected, got, stream.Size, cr)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedGetRangeNotSatisfiable(t *testing.T) {
+	tests := []struct {
+		name string
+		r    ByteRange
+	}{
+		{"start at the size", ByteRange{Start: 20, End: -1}},
+		{"start past the size", ByteRange{Start: 50, End: 60}},
+		{"empty suffix", ByteRange{Start: -1, End: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newChunkedStore(t)
+			// the first request learns the size from the origin, the second one knows it already
+			for i := 0; i < 2; i++ {
+				_, _, _, err := c.GetRange("object", tt.r, nil)
+				if !errors.Is(err, ErrRangeNotSatisfiable) {
+					t.Errorf("request %d: expected %s, got %v", i, ErrRangeNotSatisfiable, err)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedObjectSizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		chunked bool
+	}{
+		{"empty", 0, false},
+		{"smaller than a chunk", 3, false},
+		{"one chunk", 4, false},
+		{"at the threshold", 10, false},
+		{"above the threshold", 11, true},
+		{"whole chunks", 20, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object := []byte(chunkedObject)[:tt.size]
+			origin := NewMemoryStore(1<<20, 1<<20)
+			if err := origin.Put("object", object, nil); err != nil {
+				t.Fatal(err)
+			}
+			cache := NewMemoryStore(1<<20, 1<<20)
+			c := NewCachingStore("test", origin, cache).WithChunking(10, 4)
+			got, _, err := c.Get("object", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, object) {
+				t.Errorf("expected %q, got %q", object, got)
+			}
+			hashedName := hashName("object")
+			whole, _ := cache.Has(hashedName, nil)
+			chunk, _ := cache.Has(chunkKey(hashedName, 0), nil)
+			if whole == tt.chunked || chunk != tt.chunked {
+				t.Errorf("expected chunked: %t, got whole object cached: %t, first chunk cached: %t", tt.chunked, whole, chunk)
+			}
+		})
+	}
+}
+
+func TestChunkedShortChunk(t *testing.T) {
+	c, cache := newChunkedStore(t)
+	// learn the size of the object, then replace a chunk in the middle with one that's too short
+	if _, _, err := c.Get("object", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(chunkKey(hashName("object"), 2), []byte("89"), nil); err != nil {
+		t.Fatal(err)
+	}
+	stream, _, _, err := c.GetRange("object", ByteRange{Start: 0, End: -1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	_, err = io.ReadAll(stream)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected %s, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
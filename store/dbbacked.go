@@ -1,61 +1,33 @@
 package store
 
 import (
-	"database/sql"
+	"fmt"
+	"io"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/lbryio/lbry.go/v2/extras/errors"
-	qt "github.com/lbryio/lbry.go/v2/extras/query"
 	"github.com/lbryio/reflector.go/shared"
 	log "github.com/sirupsen/logrus"
 )
 
-/*
-Table description:
-CREATE TABLE `object`
-(
-    `id`               bigint unsigned                  NOT NULL AUTO_INCREMENT,
-    `hash`             char(64) COLLATE utf8_unicode_ci NOT NULL,
-    `is_stored`        tinyint(1)                       NOT NULL DEFAULT '0',
-    `length`           bigint unsigned                           DEFAULT NULL,
-    `last_accessed_at` timestamp                        NULL     DEFAULT NULL,
-    PRIMARY KEY (`id`),
-    UNIQUE KEY `id` (`id`),
-    UNIQUE KEY `hash_idx` (`hash`),
-    KEY `last_accessed_idx` (`last_accessed_at`),
-    KEY `is_stored_idx` (`is_stored`)
-);
-*/
-
 // DBBackedStore is a store that's backed by a DB. The DB contains data about what's in the store.
 type DBBackedStore struct {
 	objectsStore ObjectStore
-	conn         *sql.DB
-	done         chan bool
+	meta         MetadataStore
+	// verifiedSize is the size up to which objects are verified before they're returned
+	verifiedSize int64
 }
 
 // NewDBBackedStore returns an initialized store pointer.
-func NewDBBackedStore(objectStore ObjectStore, dsn string) *DBBackedStore {
-	conn, err := connect(dsn)
-	if err != nil {
-		log.Fatalln(errors.FullTrace(err))
-	}
-	return &DBBackedStore{objectsStore: objectStore, conn: conn, done: make(chan bool)}
+func NewDBBackedStore(objectStore ObjectStore, meta MetadataStore) *DBBackedStore {
+	return &DBBackedStore{objectsStore: objectStore, meta: meta}
 }
 
-// Connect will create a connection to the database
-func connect(dsn string) (*sql.DB, error) {
-	var err error
-	dsn += "?parseTime=1&collation=utf8mb4_unicode_ci"
-	conn, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, errors.Err(err)
-	}
-
-	conn.SetMaxIdleConns(12)
-
-	return conn, errors.Err(conn.Ping())
+// WithVerifiedSize makes the store verify objects of up to maxSize bytes before returning them, so that a corrupted
+// one is never sent. Bigger objects are only verified as they're read.
+func (d *DBBackedStore) WithVerifiedSize(maxSize int64) *DBBackedStore {
+	d.verifiedSize = maxSize
+	return d
 }
 
 const nameDBBacked = "db-backed"
@@ -63,134 +35,239 @@ const nameDBBacked = "db-backed"
 // Name is the cache type name
 func (d *DBBackedStore) Name() string { return nameDBBacked }
 
+// Metadata returns the store keeping track of the objects
+func (d *DBBackedStore) Metadata() MetadataStore {
+	return d.meta
+}
+
 // UsedSpace returns how many bytes are currently indexed by the db store
-// is_stored is always 1 in the current implementation, so avoiding that filter by passing fast=true the query return faster without any extra indexes
-func (d *DBBackedStore) UsedSpace(fast bool) (int, error) {
-	if d.conn == nil {
-		return 0, errors.Err("not connected")
-	}
-	query := `select sum(length) AS total FROM object WHERE is_stored = 1`
-	if fast {
-		query = `select sum(length) AS total FROM object`
-	}
-	row := d.conn.QueryRow(query)
-	var total int
-	err := row.Scan(&total)
-	if err != nil {
-		return 0, errors.Err(err)
-	}
-	return total, nil
+func (d *DBBackedStore) UsedSpace() (int, error) {
+	total, err := d.meta.UsedSpace()
+	return int(total), err
 }
 
 // Has returns true if the object is in the store
 func (d *DBBackedStore) Has(hash string, extra interface{}) (bool, error) {
-	stored, _, err := d.has(hash)
-	return stored, err
-}
-
-// has returns true if the object is in the store
-func (d *DBBackedStore) has(hash string) (bool, *time.Time, error) {
-	if d.conn == nil {
-		return false, nil, errors.Err("not connected")
-	}
-	query := `SELECT hash, length, is_stored, last_accessed_at FROM object WHERE hash = ?`
-	row := d.conn.QueryRow(query, hash)
-	var queriedHash string
-	var length uint
-	var stored bool
-	var lastAccess time.Time
-	err := row.Scan(&queriedHash, &length, &stored, &lastAccess)
+	record, err := d.meta.Get(hash)
+	return record != nil, err
+}
+
+// Get gets the object, making sure it's not corrupted
+func (d *DBBackedStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	start := time.Now()
+	record, err := d.lookup(hash)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return false, nil, nil
-		}
-		return false, nil, errors.Err(err)
+		return nil, shared.NewBlobTrace(time.Since(start), d.Name()), err
 	}
-	return stored, &lastAccess, nil
+	obj, stack, err := d.objectsStore.Get(hash, extra)
+	if err != nil {
+		return nil, stack.Stack(time.Since(start), d.Name()), d.checkMissing(hash, extra, err)
+	}
+	if record.Checksum != nil && checksum(obj) != *record.Checksum {
+		d.quarantine(hash, extra)
+		return nil, stack.Stack(time.Since(start), d.Name()), ErrObjectNotFound
+	}
+	d.refresh(record)
+	return obj, stack.Stack(time.Since(start), d.Name()), nil
 }
 
-// Get gets the object
-func (d *DBBackedStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+// GetStream gets the object as a stream from the underlying store. Objects up to the verified size are checked against
+// their checksum before they're returned, a corrupted one being quarantined and reported missing. The checksum of bigger
+// objects is verified as they're read: a corrupted object makes the stream fail once it's fully read, after all of it
+// was sent, and is quarantined so the next request refetches it.
+func (d *DBBackedStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
 	start := time.Now()
-	has, lastAccess, err := d.has(hash)
+	record, err := d.lookup(hash)
 	if err != nil {
-		return nil, shared.NewBlobTrace(time.Since(start), d.Name()), errors.Err(err)
+		return nil, shared.NewBlobTrace(time.Since(start), d.Name()), err
 	}
-	if !has {
-		return nil, shared.NewBlobTrace(time.Since(start), d.Name()), ErrObjectNotFound
+	stream, stack, err := GetStream(d.objectsStore, hash, extra)
+	if err != nil {
+		return nil, stack.Stack(time.Since(start), d.Name()), d.checkMissing(hash, extra, err)
+	}
+	stream.Meta = recordMeta(record)
+	switch {
+	case record.Checksum == nil:
+		// objects cached before checksums were introduced can't be verified
+	case stream.Size <= d.verifiedSize:
+		stream, err = d.verified(hash, stream, *record.Checksum, extra)
+		if err != nil {
+			return nil, stack.Stack(time.Since(start), d.Name()), err
+		}
+	default:
+		stream.ReadCloser = newVerifyingReader(stream.ReadCloser, stream.Size, *record.Checksum, func() {
+			d.quarantine(hash, extra)
+		})
 	}
+	d.refresh(record)
+	return stream, stack.Stack(time.Since(start), d.Name()), nil
+}
 
-	obj, stack, err := d.objectsStore.Get(hash, extra)
+// GetRange gets a range of the object from the underlying store. Ranges covering the whole object are verified like
+// streams are, other ranges aren't: the scrubber takes care of those.
+func (d *DBBackedStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	start := time.Now()
+	record, err := d.lookup(hash)
 	if err != nil {
-		if errors.Is(err, ErrObjectNotFound) {
-			e2 := d.Delete(hash, extra)
-			if e2 != nil {
-				log.Errorf("error while deleting object from db: %s", errors.FullTrace(err))
-			}
-			return nil, stack.Stack(time.Since(start), d.Name()), ErrObjectNotFound
+		return nil, ContentRange{}, shared.NewBlobTrace(time.Since(start), d.Name()), err
+	}
+	stream, cr, stack, err := GetRange(d.objectsStore, hash, r, extra)
+	if err != nil {
+		return nil, cr, stack.Stack(time.Since(start), d.Name()), d.checkMissing(hash, extra, err)
+	}
+	stream.Meta = recordMeta(record)
+	switch {
+	case record.Checksum == nil || cr.Start != 0 || cr.Length() != cr.Size:
+		// only whole objects can be verified
+	case stream.Size <= d.verifiedSize:
+		stream, err = d.verified(hash, stream, *record.Checksum, extra)
+		if err != nil {
+			return nil, cr, stack.Stack(time.Since(start), d.Name()), err
 		}
+	default:
+		stream.ReadCloser = newVerifyingReader(stream.ReadCloser, stream.Size, *record.Checksum, func() {
+			d.quarantine(hash, extra)
+		})
+	}
+	d.refresh(record)
+	return stream, cr, stack.Stack(time.Since(start), d.Name()), nil
+}
+
+// recordMeta returns the metadata of the object completed with what the cache knows about it: objects the origin
+// didn't give an ETag get one derived from their checksum
+func recordMeta(record *ObjectRecord) *ObjectMeta {
+	var meta ObjectMeta
+	if record.Meta != nil {
+		meta = *record.Meta
+	}
+	if meta.ETag == "" && record.Checksum != nil {
+		meta.ETag = fmt.Sprintf(`"%08x"`, *record.Checksum)
+		meta.checksumETag = true
 	}
-	if lastAccess.Before(time.Now().Add(-6 * time.Hour)) {
-		err = d.touch(hash)
+	return meta.orNil()
+}
+
+// lookup returns the record of the object or ErrObjectNotFound if the db doesn't know about it
+func (d *DBBackedStore) lookup(hash string) (*ObjectRecord, error) {
+	record, err := d.meta.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrObjectNotFound
+	}
+	return record, nil
+}
+
+// checkMissing removes the object from the db if the underlying store lost it
+func (d *DBBackedStore) checkMissing(hash string, extra interface{}, err error) error {
+	if !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	e2 := d.Delete(hash, extra)
+	if e2 != nil {
+		log.Errorf("error while deleting object from db: %s", errors.FullTrace(e2))
+	}
+	return ErrObjectNotFound
+}
+
+// refreshInterval is how often the last access time of an object is updated at most, to spare the db
+const refreshInterval = 6 * time.Hour
+
+// refresh updates the last access time of the object, at most once every refreshInterval
+func (d *DBBackedStore) refresh(record *ObjectRecord) {
+	if record.LastAccessedAt.Before(time.Now().Add(-refreshInterval)) {
+		err := d.meta.Touch(record.Hash, time.Now())
 		if err != nil {
 			log.Errorf("error while updating object's last access time on db: %s", errors.FullTrace(err))
 		}
 	}
-	return obj, stack.Stack(time.Since(start), d.Name()), err
 }
 
-func (d *DBBackedStore) touch(hash string) error {
-	if d.conn == nil {
-		return errors.Err("not connected")
-	}
-	query := `UPDATE object set last_accessed_at = ? WHERE hash = ?`
-	_, err := d.conn.Exec(query, time.Now(), hash)
-	return errors.Err(err)
+// Touch records an access to the object served without going through the store
+func (d *DBBackedStore) Touch(hash string) error {
+	return d.meta.Touch(hash, time.Now())
 }
 
-// Put stores the object in the S3 store and stores the object information in the DB.
+// Put stores the object in the underlying store and stores the object information in the DB.
 func (d *DBBackedStore) Put(hash string, object []byte, extra interface{}) error {
-	if d.conn == nil {
-		return errors.Err("not connected")
-	}
-	err := d.objectsStore.Put(hash, object, extra)
+	return d.PutStream(hash, NewObjectStream(object), extra)
+}
+
+// PutStream streams the object into the underlying store and stores the object information, including its metadata,
+// its original name and the origin it came from, in the DB.
+func (d *DBBackedStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	hasher := newChecksum()
+	err := PutStream(d.objectsStore, hash, &ObjectStream{
+		ReadCloser: limitedReadCloser{Reader: io.TeeReader(object, hasher), Closer: object},
+		Size:       object.Size,
+	}, extra)
 	if err != nil {
 		return err
 	}
-	args := []interface{}{hash, true, len(object), time.Now()}
-	query := `INSERT INTO object (hash,is_stored,length,last_accessed_at) VALUES(` + qt.Qs(len(args)) + `) ON DUPLICATE KEY UPDATE is_stored = (is_stored or VALUES(is_stored)), last_accessed_at = VALUES(last_accessed_at)`
-	_, err = d.conn.Exec(query, args...)
-	return errors.Err(err)
+	sum := hasher.Sum32()
+	meta := ObjectMeta{}
+	if object.Meta != nil {
+		meta = *object.Meta
+	}
+	meta.CachedAt = time.Now().UTC().Truncate(time.Second)
+	var origin *int
+	if ex, ok := extra.(MultiS3Extras); ok {
+		origin = &ex.S3Index
+	}
+	return d.meta.Insert(ObjectRecord{
+		Hash:           hash,
+		Length:         object.Size,
+		LastAccessedAt: time.Now(),
+		Checksum:       &sum,
+		Meta:           &meta,
+		Name:           object.Name,
+		Origin:         origin,
+	})
 }
 
-func (d *DBBackedStore) Delete(hash string, extra interface{}) error {
-	if d.conn == nil {
-		return errors.Err("not connected")
+// UpdateMeta replaces the metadata of the object. ETags derived from the checksum aren't stored, they're derived again
+// when the object is read.
+func (d *DBBackedStore) UpdateMeta(hash string, meta *ObjectMeta) error {
+	if meta != nil && meta.checksumETag {
+		m := *meta
+		m.ETag = ""
+		m.checksumETag = false
+		meta = &m
 	}
+	return d.meta.UpdateMeta(hash, meta)
+}
+
+func (d *DBBackedStore) Delete(hash string, extra interface{}) error {
 	err := d.objectsStore.Delete(hash, extra)
 	if err != nil {
 		return err
 	}
-	query := `DELETE FROM object WHERE hash = ?`
-	_, err = d.conn.Exec(query, hash)
-	return errors.Err(err)
+	return d.meta.Delete(hash)
 }
 
 // Shutdown shuts down the store gracefully
 func (d *DBBackedStore) Shutdown() {
-	d.done <- true
 	d.objectsStore.Shutdown()
+	err := d.meta.Close()
+	if err != nil {
+		log.Errorf("error closing metadata store: %s", errors.FullTrace(err))
+	}
 }
 
 // LeastRecentlyAccessedObjects retrieves as many objects from the database as needed to match totalSize in occupied bytes
 func (d *DBBackedStore) LeastRecentlyAccessedObjects(totalSize int) ([]string, error) {
-	if d.conn == nil {
-		return nil, errors.Err("not connected")
-	}
+	return d.LeastRecentlyAccessedObjectsMatching(totalSize, nil)
+}
+
+// LeastRecentlyAccessedObjectsMatching is like LeastRecentlyAccessedObjects but only considers the objects for which match
+// returns true. A nil match considers all objects.
+func (d *DBBackedStore) LeastRecentlyAccessedObjectsMatching(totalSize int, match func(hash string) bool) ([]string, error) {
+	const limit = 50000
 	retrievedSize := 0
 	hashes := make([]string, 0, 1000)
 	for i := 0; retrievedSize < totalSize; i++ {
-		objects, err := d.leastRecentlyAccessedObjects(i)
+		objects, err := d.meta.LeastRecentlyAccessed(i*limit, limit)
 		if err != nil {
 			return nil, err
 		}
@@ -198,8 +275,11 @@ func (d *DBBackedStore) LeastRecentlyAccessedObjects(totalSize int) ([]string, e
 			return hashes, nil
 		}
 		for _, o := range objects {
-			retrievedSize += o.size
-			hashes = append(hashes, o.hash)
+			if match != nil && !match(o.Hash) {
+				continue
+			}
+			retrievedSize += int(o.Length)
+			hashes = append(hashes, o.Hash)
 			if retrievedSize >= totalSize {
 				return hashes, nil
 			}
@@ -207,43 +287,3 @@ func (d *DBBackedStore) LeastRecentlyAccessedObjects(totalSize int) ([]string, e
 	}
 	return hashes, nil
 }
-
-type dbObject struct {
-	hash string
-	size int
-}
-
-//leastRecentlyAccessedObjects retrieves objects in chunks at a time starting from lastOffset
-func (d *DBBackedStore) leastRecentlyAccessedObjects(lastOffset int) ([]dbObject, error) {
-	limit := 50000
-	fast := true
-
-	query := "SELECT hash, length from object where is_stored = 1 order by last_accessed_at limit ? offset ?"
-	if fast {
-		query = "SELECT hash, length from object order by last_accessed_at limit ? offset ?"
-	}
-
-	rows, err := d.conn.Query(query, limit, lastOffset*limit)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, errors.Err(err)
-	}
-	defer rows.Close()
-
-	objects := make([]dbObject, 0, limit)
-	for rows.Next() {
-		var hash string
-		var size int
-		err := rows.Scan(&hash, &size)
-		if err != nil {
-			return nil, errors.Err(err)
-		}
-		objects = append(objects, dbObject{
-			hash: hash,
-			size: size,
-		})
-	}
-	return objects, nil
-}
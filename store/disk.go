@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/lbryio/reflector.go/shared"
-	"github.com/lbryio/reflector.go/store/speedwalk"
 
 	"github.com/lbryio/lbry.go/v2/extras/errors"
 )
@@ -64,6 +63,43 @@ func (d *DiskStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrac
 	return object, shared.NewBlobTrace(time.Since(start), d.Name()), nil
 }
 
+// GetStream returns the object file opened for reading or an error if the object doesn't exist.
+func (d *DiskStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+
+	f, err := os.Open(d.path(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, shared.NewBlobTrace(time.Since(start), d.Name()), errors.Err(ErrObjectNotFound)
+		}
+		return nil, shared.NewBlobTrace(time.Since(start), d.Name()), errors.Err(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, shared.NewBlobTrace(time.Since(start), d.Name()), errors.Err(err)
+	}
+	return &ObjectStream{ReadCloser: f, Size: info.Size()}, shared.NewBlobTrace(time.Since(start), d.Name()), nil
+}
+
+// GetRange returns the object file positioned at the start of the requested range
+func (d *DiskStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	stream, trace, err := d.GetStream(hash, extra)
+	if err != nil {
+		return nil, ContentRange{}, trace, err
+	}
+	cr, err := sliceStream(stream, r)
+	if err != nil {
+		return nil, cr, trace, err
+	}
+	return stream, cr, trace, nil
+}
+
+// Put stores the object on disk
+func (d *DiskStore) Put(hash string, object []byte, extra interface{}) error {
+	return d.PutStream(hash, NewObjectStream(object), extra)
+}
+
 // Delete deletes the object from the store
 func (d *DiskStore) Delete(hash string, extra interface{}) error {
 	err := os.Remove(d.path(hash))
@@ -73,9 +109,84 @@ func (d *DiskStore) Delete(hash string, extra interface{}) error {
 	return errors.Err(err)
 }
 
-// list returns the hashes of objects that already exist in the objectDir
-func (d *DiskStore) list() ([]string, error) {
-	return speedwalk.AllFiles(d.objectDir, true)
+// QuarantineDir is the directory, inside the object directory, where corrupted objects are set aside
+const QuarantineDir = "quarantine"
+
+// Quarantine moves the object out of the store into the quarantine directory, dating it to the time it was quarantined
+func (d *DiskStore) Quarantine(hash string) error {
+	err := d.ensureDirExists(path.Join(d.objectDir, QuarantineDir))
+	if err != nil {
+		return err
+	}
+	dst := path.Join(d.objectDir, QuarantineDir, hash)
+	err = os.Rename(d.path(hash), dst)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Err(err)
+	}
+	// the file keeps the time it was written at, quarantined objects are pruned based on when they were quarantined
+	now := time.Now()
+	return errors.Err(os.Chtimes(dst, now, now))
+}
+
+// Walk calls fn for every object stored in the objectDir, leaving out temporary and quarantined files. Files that
+// aren't in the prefix directory their name maps to are reported as misplaced. Only the layout used by the store is
+// walked, so anything else kept in the objectDir is left alone.
+func (d *DiskStore) Walk(fn func(object StoredObject) error) error {
+	entries, err := os.ReadDir(d.objectDir)
+	if err != nil {
+		return errors.Err(err)
+	}
+	for _, entry := range entries {
+		if d.prefixLength <= 0 {
+			if entry.IsDir() {
+				continue
+			}
+			err = d.walkEntry(d.objectDir, entry, fn)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.IsDir() || len(entry.Name()) != d.prefixLength || entry.Name() == "tmp" {
+			continue
+		}
+		subEntries, err := os.ReadDir(path.Join(d.objectDir, entry.Name()))
+		if err != nil {
+			return errors.Err(err)
+		}
+		for _, subEntry := range subEntries {
+			if subEntry.IsDir() {
+				continue
+			}
+			err = d.walkEntry(path.Join(d.objectDir, entry.Name()), subEntry, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DiskStore) walkEntry(dir string, entry os.DirEntry, fn func(object StoredObject) error) error {
+	info, err := entry.Info()
+	if err != nil {
+		if os.IsNotExist(err) {
+			// deleted while walking
+			return nil
+		}
+		return errors.Err(err)
+	}
+	objectPath := path.Join(dir, entry.Name())
+	return fn(StoredObject{
+		Hash:      entry.Name(),
+		Path:      objectPath,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Misplaced: objectPath != d.path(entry.Name()),
+	})
 }
 
 func (d *DiskStore) dir(hash string) string {
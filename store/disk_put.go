@@ -4,31 +4,33 @@
 package store
 
 import (
-	"bytes"
 	"io"
 	"os"
 
 	"github.com/lbryio/lbry.go/v2/extras/errors"
 )
 
-var openFileFlags = os.O_WRONLY | os.O_CREATE
+var openFileFlags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
 
-// Put stores the object on disk
-func (d *DiskStore) Put(hash string, object []byte, extra interface{}) error {
+// PutStream streams the object on disk
+func (d *DiskStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
 	err := d.ensureDirExists(d.dir(hash))
 	if err != nil {
 		return err
 	}
 
-	// Open file with O_DIRECT
 	f, err := os.OpenFile(d.tmpPath(hash), openFileFlags, 0644)
 	if err != nil {
 		return errors.Err(err)
 	}
 	defer f.Close()
 
-	_, err = io.Copy(f, bytes.NewReader(object))
-	if err != nil {
+	written, err := io.CopyN(f, object, object.Size)
+	if err != nil || written != object.Size {
+		_ = os.Remove(d.tmpPath(hash))
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
 		return errors.Err(err)
 	}
 	err = os.Rename(d.tmpPath(hash), d.path(hash))
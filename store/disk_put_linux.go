@@ -1,7 +1,6 @@
 package store
 
 import (
-	"bytes"
 	"io"
 	"os"
 	"syscall"
@@ -10,10 +9,10 @@ import (
 	"github.com/lbryio/lbry.go/v2/extras/errors"
 )
 
-var openFileFlags = os.O_WRONLY | os.O_CREATE | syscall.O_DIRECT
+var openFileFlags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC | syscall.O_DIRECT
 
-// Put stores the object on disk
-func (d *DiskStore) Put(hash string, object []byte, extra interface{}) error {
+// PutStream streams the object on disk
+func (d *DiskStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
 	err := d.ensureDirExists(d.dir(hash))
 	if err != nil {
 		return err
@@ -31,10 +30,16 @@ func (d *DiskStore) Put(hash string, object []byte, extra interface{}) error {
 	if err != nil {
 		return errors.Err(err)
 	}
-	defer dio.Flush()
 	// Write the body to file
-	_, err = io.Copy(dio, bytes.NewReader(object))
-	if err != nil {
+	written, err := io.CopyN(dio, object, object.Size)
+	if err == nil {
+		err = dio.Flush()
+	}
+	if err != nil || written != object.Size {
+		_ = os.Remove(d.tmpPath(hash))
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
 		return errors.Err(err)
 	}
 	err = os.Rename(d.tmpPath(hash), d.path(hash))
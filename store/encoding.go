@@ -0,0 +1,95 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+)
+
+// Encodings that objects can be compressed with, named as in the Content-Encoding header
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+)
+
+// encoders return a writer compressing into w with the encoding they're keyed by
+var encoders = map[string]func(w io.Writer) io.WriteCloser{
+	EncodingGzip: func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	EncodingBrotli: func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	},
+}
+
+// encodedKey is the name under which the object compressed with the encoding is cached
+func encodedKey(hashedName, encoding string) string {
+	return hashedName + "-" + encoding
+}
+
+// encodedETag returns the ETag of the object compressed with the encoding. Every representation of an object needs
+// its own ETag, the encoding is appended to the one of the object.
+func encodedETag(etag, encoding string) string {
+	if etag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// GetEncoded returns the object compressed with the encoding. The compressed object is cached next to the object so
+// it's only compressed once. meta is what's known about the current version of the object: a cached compressed copy
+// of another version is replaced.
+func (c *CachingStore) GetEncoded(originalName, encoding string, meta *ObjectMeta, extra interface{}) (*ObjectStream, error) {
+	encode, ok := encoders[encoding]
+	if !ok {
+		return nil, errors.Err("unsupported encoding %s", encoding)
+	}
+	var etag string
+	if meta != nil {
+		etag = encodedETag(meta.ETag, encoding)
+	}
+	key := encodedKey(hashName(originalName), encoding)
+	stream, _, err := GetStream(c.cache, key, extra)
+	if err == nil {
+		if stream.Meta != nil && stream.Meta.ETag == etag {
+			return stream, nil
+		}
+		_ = stream.Close()
+	} else if !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+	_, err = c.fillWith(originalName, key, extra, func() (*ObjectStream, shared.BlobTrace, error) {
+		original, trace, err := c.GetStream(originalName, extra)
+		if err != nil {
+			return nil, trace, err
+		}
+		defer original.Close()
+		buf := &bytes.Buffer{}
+		w := encode(buf)
+		_, err = io.Copy(w, original)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return nil, trace, errors.Err(err)
+		}
+		encoded := NewObjectStream(buf.Bytes())
+		encoded.Meta = &ObjectMeta{}
+		if original.Meta != nil {
+			*encoded.Meta = *original.Meta
+		}
+		encoded.Meta.ETag = encodedETag(encoded.Meta.ETag, encoding)
+		encoded.Meta.ContentEncoding = encoding
+		return encoded, trace, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stream, _, err = GetStream(c.cache, key, extra)
+	return stream, err
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// FreshnessRule sets how long the cached objects whose name matches Pattern are served before being revalidated
+// against the origin. Once stale, they're still served for StaleWhileRevalidate while being revalidated in the
+// background, and for StaleIfError when the origin can't be reached.
+type FreshnessRule struct {
+	Pattern              *regexp.Regexp
+	TTL                  time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+// freshness holds the settings for revalidating cached objects
+type freshness struct {
+	rules []FreshnessRule
+	// revalidations makes sure an object is only revalidated once at a time
+	revalidations *singleflight.Group
+}
+
+// WithFreshness makes cached objects go stale after the TTL of the first rule matching their name, objects matching
+// no rule staying fresh forever. Stale objects are revalidated against the origin and fetched again if the origin's
+// copy changed. Objects cached in chunks are revalidated as a whole, with the metadata of their first chunk.
+func (c *CachingStore) WithFreshness(rules []FreshnessRule) *CachingStore {
+	c.freshness = &freshness{
+		rules:         rules,
+		revalidations: new(singleflight.Group),
+	}
+	return c
+}
+
+// rule returns the first rule matching the name, nil if there's none
+func (f *freshness) rule(originalName string) *FreshnessRule {
+	for i := range f.rules {
+		if f.rules[i].Pattern.MatchString(originalName) {
+			return &f.rules[i]
+		}
+	}
+	return nil
+}
+
+// checkFreshness tells whether a cached copy of an object of the given size can be served: it's fresh, it was
+// revalidated, or it's stale but can still be served. It returns false without an error when the cached copy was
+// dropped because the origin's copy changed, in which case the object has to be fetched again. chunked is set for objects
+// cached in chunks, meta being the metadata of their first chunk.
+func (c *CachingStore) checkFreshness(originalName, hashedName string, meta *ObjectMeta, size int64, chunked bool, extra interface{}) (bool, error) {
+	if c.freshness == nil {
+		return true, nil
+	}
+	rule := c.freshness.rule(originalName)
+	if rule == nil {
+		return true, nil
+	}
+	if meta == nil {
+		meta = &ObjectMeta{}
+	}
+	// objects cached before their caching time was recorded are as stale as can be
+	age := time.Since(meta.validated())
+	if age <= rule.TTL {
+		return true, nil
+	}
+	if age <= rule.TTL+rule.StaleWhileRevalidate {
+		go func() {
+			_, err := c.revalidate(originalName, hashedName, meta, size, chunked, extra, true)
+			if err != nil {
+				log.Errorf("error revalidating %s in the background: %s", originalName, errors.FullTrace(err))
+			}
+		}()
+		return true, nil
+	}
+	fresh, err := c.revalidate(originalName, hashedName, meta, size, chunked, extra, false)
+	if err != nil {
+		if age <= rule.TTL+rule.StaleIfError {
+			log.Warnf("serving stale %s, the origin couldn't revalidate it: %s", originalName, err.Error())
+			return true, nil
+		}
+		return false, err
+	}
+	return fresh, nil
+}
+
+// freshChunked tells whether the chunks of an object of the given size can be served, see checkFreshness. The chunks
+// are dropped if the first one isn't cached anymore, there's no telling which version of the object they belong to.
+func (c *CachingStore) freshChunked(originalName, hashedName string, size int64, extra interface{}) (bool, error) {
+	if c.freshness == nil || c.freshness.rule(originalName) == nil {
+		return true, nil
+	}
+	// the metadata comes along with any stream, a single byte is as good as the whole chunk
+	stream, _, _, err := GetRange(c.cache, chunkKey(hashedName, 0), ByteRange{Start: 0, End: 0}, extra)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, c.dropCached(hashedName)
+	}
+	if err != nil {
+		return false, err
+	}
+	_ = stream.Close()
+	return c.checkFreshness(originalName, hashedName, stream.Meta, size, true, extra)
+}
+
+// fresh returns the cached stream if it can be served. Otherwise the stream is closed and ErrObjectNotFound is returned
+// if the object has to be fetched again.
+func (c *CachingStore) fresh(originalName, hashedName string, stream *ObjectStream, size int64, extra interface{}) (*ObjectStream, error) {
+	serve, err := c.checkFreshness(originalName, hashedName, stream.Meta, size, false, extra)
+	if serve {
+		return stream, nil
+	}
+	_ = stream.Close()
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.Err(ErrObjectNotFound)
+}
+
+// revalidate asks the origin whether the cached copy is still the current one with a conditional request, recording
+// when it was validated if it is and dropping it otherwise. When refill is set the changed object is fetched again right
+// away, unless it's cached in chunks: those are fetched again as they're read. It returns true if the cached copy is
+// still good.
+func (c *CachingStore) revalidate(originalName, hashedName string, meta *ObjectMeta, size int64, chunked bool, extra interface{}, refill bool) (bool, error) {
+	f, err, _ := c.freshness.revalidations.Do(hashedName, func() (interface{}, error) {
+		current, currentSize, err := c.revalidateOrigin(originalName, meta, extra)
+		if errors.Is(err, ErrNotModified) {
+			c.markValidated(hashedName, meta, chunked)
+			return true, nil
+		}
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				// the origin doesn't have the object anymore
+				c.invalidate(hashedName)
+				return false, nil
+			}
+			return false, err
+		}
+		// origins that can't make conditional requests send the metadata of their copy to compare with the cached one
+		if !sameVersion(meta, size, current, currentSize) {
+			log.Debugf("%s changed on the origin, dropping the cached copy", originalName)
+			c.invalidate(hashedName)
+			if refill && !chunked {
+				_, err := c.fill(originalName, hashedName, extra)
+				if err != nil {
+					return false, err
+				}
+			}
+			return false, nil
+		}
+		c.markValidated(hashedName, meta, chunked)
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return f.(bool), nil
+}
+
+// revalidateOrigin asks the origin whether the copy of the object described by meta is still current, see Revalidate
+func (c *CachingStore) revalidateOrigin(originalName string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, error) {
+	if c.baseFuncs == nil {
+		current, size, _, err := Revalidate(c.origin, originalName, meta, extra)
+		return current, size, err
+	}
+	stream, cr, _, err := c.getOriginRange(originalName, ByteRange{Start: 0, End: 0}, extra)
+	return rangeMeta(stream, cr, err)
+}
+
+// sameVersion returns true if the cached copy and the origin's copy are the same version of the object, comparing
+// their ETags, or their modification times if either has no ETag, and their sizes
+func sameVersion(cached *ObjectMeta, cachedSize int64, current *ObjectMeta, currentSize int64) bool {
+	if cachedSize != currentSize {
+		return false
+	}
+	if current == nil {
+		return true
+	}
+	if cached.ETag != "" && current.ETag != "" {
+		return cached.ETag == current.ETag
+	}
+	if !cached.LastModified.IsZero() && !current.LastModified.IsZero() {
+		return cached.LastModified.Equal(current.LastModified)
+	}
+	return true
+}
+
+// markValidated records that the cached copy was found to be current, on the first chunk of objects cached in chunks
+func (c *CachingStore) markValidated(hashedName string, meta *ObjectMeta, chunked bool) {
+	validated := *meta
+	validated.ValidatedAt = time.Now().UTC().Truncate(time.Second)
+	key := hashedName
+	if chunked {
+		key = chunkKey(hashedName, 0)
+	} else if c.hot != nil {
+		c.hot.setMeta(hashedName, &validated)
+	}
+	updater, ok := unwrap(c.cache).(metaUpdater)
+	if !ok {
+		return
+	}
+	err := updater.UpdateMeta(key, &validated)
+	if err != nil {
+		log.Errorf("error recording the validation of %s: %s", hashedName, errors.FullTrace(err))
+	}
+}
+
+// invalidate drops every cached copy of the object, see dropCached
+func (c *CachingStore) invalidate(hashedName string) {
+	err := c.dropCached(hashedName)
+	if err != nil {
+		log.Errorf("error dropping the cached copy of %s: %s", hashedName, errors.FullTrace(err))
+	}
+}
+
+// metaUpdater is implemented by stores that can update the metadata of the objects they hold
+type metaUpdater interface {
+	UpdateMeta(hash string, meta *ObjectMeta) error
+}
+
+// unwrap returns the store wrapped by a single flight store
+func unwrap(s ObjectStore) ObjectStore {
+	if sf, ok := s.(*singleFlightStore); ok {
+		return sf.ObjectStore
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// testOrigin serves a single object, honoring ranges and conditional requests, and records the requests it gets
+type testOrigin struct {
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	modified time.Time
+	missing  bool
+	requests []*http.Request
+}
+
+func (o *testOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = append(o.requests, r)
+	if o.missing {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if o.etag != "" {
+		w.Header().Set("ETag", o.etag)
+	}
+	http.ServeContent(w, r, "object", o.modified, bytes.NewReader(o.content))
+}
+
+func (o *testOrigin) update(fn func(o *testOrigin)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fn(o)
+	o.requests = nil
+}
+
+func (o *testOrigin) received() []*http.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests
+}
+
+func TestRevalidation(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		etag   string
+		change func(o *testOrigin)
+		// condition is the conditional header expected on the revalidation
+		condition string
+		// requests is how many requests the origin is expected to get once the object is cached, whole or in chunks
+		requests, chunkedRequests int
+		expected                  string
+		missing                   bool
+	}{
+		{"unchanged", `"v1"`, func(o *testOrigin) {}, "If-None-Match", 1, 1, "version 1", false},
+		{"changed", `"v1"`, func(o *testOrigin) { o.content, o.etag = []byte("version 2"), `"v2"` }, "If-None-Match", 2, 4, "version 2", false},
+		{"unchanged without etag", "", func(o *testOrigin) {}, "If-Modified-Since", 1, 1, "version 1", false},
+		{"changed without etag", "", func(o *testOrigin) {
+			o.content, o.modified = []byte("version 2"), o.modified.Add(time.Hour)
+		}, "If-Modified-Since", 2, 4, "version 2", false},
+		{"deleted", `"v1"`, func(o *testOrigin) { o.missing = true }, "If-None-Match", 2, 2, "", true},
+	}
+	for _, tt := range tests {
+		for _, chunked := range []bool{false, true} {
+			name, requests := tt.name, tt.requests
+			if chunked {
+				name, requests = tt.name+" in chunks", tt.chunkedRequests
+			}
+			t.Run(name, func(t *testing.T) {
+				origin := &testOrigin{content: []byte("version 1"), etag: tt.etag, modified: modified}
+				server := httptest.NewServer(origin)
+				defer server.Close()
+				c := NewCachingStore("test", NewHTTPStore(server.URL, nil, time.Second, nil), NewMemoryStore(1<<20, 1<<20)).
+					WithFreshness([]FreshnessRule{{Pattern: regexp.MustCompile(".*")}})
+				if chunked {
+					// the object is cached in 3 chunks
+					c.WithChunking(4, 4)
+				}
+				if got := getString(t, c); got != "version 1" {
+					t.Fatalf("expected version 1, got %q", got)
+				}
+
+				origin.update(tt.change)
+				stream, _, err := c.GetStream("object", nil)
+				if tt.missing {
+					if !errors.Is(err, ErrObjectNotFound) {
+						t.Errorf("expected %s, got %v", ErrObjectNotFound, err)
+					}
+				} else {
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := io.ReadAll(stream)
+					_ = stream.Close()
+					if err != nil || string(got) != tt.expected {
+						t.Errorf("expected %q, got %q (%v)", tt.expected, got, err)
+					}
+				}
+				received := origin.received()
+				if len(received) != requests {
+					t.Fatalf("expected %d requests to the origin, got %d", requests, len(received))
+				}
+				if received[0].Header.Get(tt.condition) == "" {
+					t.Errorf("expected the revalidation to carry %s, got %v", tt.condition, received[0].Header)
+				}
+			})
+		}
+	}
+}
+
+func getString(t *testing.T, s ObjectStore) string {
+	object, _, err := s.Get("object", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(object)
+}
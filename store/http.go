@@ -0,0 +1,307 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTPStore is a read only store fetching objects from an HTTP(S) server, the name of the object being the path
+// relative to the base URL
+type HTTPStore struct {
+	name    string
+	baseURL string
+	headers map[string]string
+	// notFound holds the status codes meaning that the object doesn't exist
+	notFound map[int]bool
+	client   *http.Client
+	// query optionally returns the query string parameters to send for an object
+	query func(hash string, extra interface{}) url.Values
+	// mergeVia makes the traces include the trace the server sends in its Via header
+	mergeVia bool
+}
+
+// HTTPStatusError is returned when the server answers with an unexpected status code
+type HTTPStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s answered with status %d", e.URL, e.StatusCode)
+}
+
+// NewHTTPStore returns an initialized HTTP store pointer. headers are sent with every request, timeout limits how long
+// the server can take to start answering and notFoundStatuses are the status codes to treat as a missing object
+// (404 if empty).
+func NewHTTPStore(baseURL string, headers map[string]string, timeout time.Duration, notFoundStatuses []int) *HTTPStore {
+	if len(notFoundStatuses) == 0 {
+		notFoundStatuses = []int{http.StatusNotFound}
+	}
+	notFound := make(map[int]bool, len(notFoundStatuses))
+	for _, status := range notFoundStatuses {
+		notFound[status] = true
+	}
+	return &HTTPStore{
+		name:     nameHTTP,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		headers:  headers,
+		notFound: notFound,
+		client: &http.Client{
+			// the timeouts don't cover reading the body, which can legitimately take a long time for big objects
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+				TLSHandshakeTimeout:   timeout,
+				ResponseHeaderTimeout: timeout,
+				MaxIdleConnsPerHost:   100,
+				IdleConnTimeout:       90 * time.Second,
+				// objects are cached as they're served: letting the transport ask for gzip would have other nodes
+				// answer with their compressed copies, whose length and ETag aren't those of the object
+				DisableCompression: true,
+			},
+		},
+	}
+}
+
+const nameHTTP = "http"
+
+// Name is the cache type name
+func (h *HTTPStore) Name() string { return h.name }
+
+// traceName identifies the server in traces
+func (h *HTTPStore) traceName() string {
+	u, err := url.Parse(h.baseURL)
+	if err != nil {
+		return h.Name()
+	}
+	return h.Name() + ":" + u.Host
+}
+
+// trace returns the trace of a request started at start. When mergeVia is set, the trace the server sent along with
+// the response is included.
+func (h *HTTPStore) trace(start time.Time, res *http.Response) shared.BlobTrace {
+	if !h.mergeVia || res == nil || res.Header.Get("Via") == "" {
+		return shared.NewBlobTrace(time.Since(start), h.traceName())
+	}
+	trace, err := shared.Deserialize(res.Header.Get("Via"))
+	if err != nil {
+		log.Debugf("invalid trace from %s: %s", h.baseURL, err.Error())
+		return shared.NewBlobTrace(time.Since(start), h.traceName())
+	}
+	return trace.Stack(time.Since(start), h.traceName())
+}
+
+// Has returns whether the server has the object
+func (h *HTTPStore) Has(hash string, extra interface{}) (bool, error) {
+	res, err := h.do(http.MethodHead, hash, extra, nil)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	_ = res.Body.Close()
+	return true, nil
+}
+
+// Get returns the object downloaded from the server
+func (h *HTTPStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	start := time.Now()
+	log.Debugf("Getting %s from %s", truncate(hash), h.baseURL)
+	res, err := h.do(http.MethodGet, hash, extra, nil)
+	if err != nil {
+		return nil, h.trace(start, res), err
+	}
+	stream, err := h.stream(res)
+	if err != nil {
+		return nil, h.trace(start, res), err
+	}
+	defer stream.Close()
+	object, err := readAll(stream)
+	if err != nil {
+		return nil, h.trace(start, res), errors.Err(err)
+	}
+	return object, h.trace(start, res), nil
+}
+
+// GetStream returns the body of the object as it's being downloaded from the server
+func (h *HTTPStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	log.Debugf("Streaming %s from %s", truncate(hash), h.baseURL)
+	res, err := h.do(http.MethodGet, hash, extra, nil)
+	if err != nil {
+		return nil, h.trace(start, res), err
+	}
+	stream, err := h.stream(res)
+	return stream, h.trace(start, res), err
+}
+
+// GetRange returns a stream of the requested range of the object as it's being downloaded from the server
+func (h *HTTPStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	log.Debugf("Streaming %s of %s from %s", r.String(), truncate(hash), h.baseURL)
+	return h.getRange(hash, r, extra, map[string]string{})
+}
+
+// Revalidate asks the server for the first byte of the object, conditionally on the object having changed since the
+// copy described by meta. Servers answer with a 304 when it hasn't, the first byte carrying the metadata and the size
+// of the object otherwise. Conditional GETs are used rather than HEAD requests, which other gody-cdn nodes only
+// answer with the object's size when they have it cached.
+func (h *HTTPStore) Revalidate(hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error) {
+	log.Debugf("Revalidating %s on %s", truncate(hash), h.baseURL)
+	stream, cr, trace, err := h.getRange(hash, ByteRange{Start: 0, End: 0}, extra, conditionalHeaders(meta))
+	current, size, err := rangeMeta(stream, cr, err)
+	return current, size, trace, err
+}
+
+// getRange requests the range of the object along with the given headers
+func (h *HTTPStore) getRange(hash string, r ByteRange, extra interface{}, headers map[string]string) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	start := time.Now()
+	headers["Range"] = r.String()
+	res, err := h.do(http.MethodGet, hash, extra, headers)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			cr, _ := parseContentRange(res.Header.Get("Content-Range"))
+			return nil, ContentRange{Size: cr.Size}, h.trace(start, res), errors.Err(ErrRangeNotSatisfiable)
+		}
+		return nil, ContentRange{}, h.trace(start, res), err
+	}
+	stream, err := h.stream(res)
+	if err != nil {
+		return nil, ContentRange{}, h.trace(start, res), err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		// the server doesn't support ranges and sent the whole object
+		cr, err := sliceStream(stream, r)
+		return stream, cr, h.trace(start, res), err
+	}
+	cr, err := parseContentRange(res.Header.Get("Content-Range"))
+	if err != nil {
+		_ = stream.Close()
+		return nil, cr, h.trace(start, res), err
+	}
+	return stream, cr, h.trace(start, res), nil
+}
+
+// do sends the request for the object. Status codes meaning the object is missing are turned into ErrObjectNotFound,
+// 304 into ErrNotModified and other unsuccessful ones into an HTTPStatusError. The response is returned along with
+// these errors, with its body already closed.
+func (h *HTTPStore) do(method string, hash string, extra interface{}, headers map[string]string) (*http.Response, error) {
+	u := h.objectURL(hash)
+	if h.query != nil {
+		if query := h.query(hash, extra); len(query) > 0 {
+			u += "?" + query.Encode()
+		}
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	_ = res.Body.Close()
+	if h.notFound[res.StatusCode] {
+		return res, errors.Err(ErrObjectNotFound)
+	}
+	if res.StatusCode == http.StatusNotModified {
+		return res, errors.Err(ErrNotModified)
+	}
+	return res, errors.Err(&HTTPStatusError{StatusCode: res.StatusCode, URL: u})
+}
+
+// spoolMemory is how much of an object of unknown size is read in memory, bigger objects are spooled to a file
+const spoolMemory = 1 << 20
+
+// stream turns the response into an object stream. Objects of unknown size are downloaded to learn their size: small
+// ones are read in memory, bigger ones are spooled to a temporary file.
+func (h *HTTPStore) stream(res *http.Response) (*ObjectStream, error) {
+	meta := metaFromHeader(res.Header)
+	if res.ContentLength >= 0 {
+		return &ObjectStream{ReadCloser: res.Body, Size: res.ContentLength, Meta: meta}, nil
+	}
+	defer res.Body.Close()
+	head, err := io.ReadAll(io.LimitReader(res.Body, spoolMemory+1))
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	if len(head) <= spoolMemory {
+		stream := NewObjectStream(head)
+		stream.Meta = meta
+		return stream, nil
+	}
+	f, size, err := spool(io.MultiReader(bytes.NewReader(head), res.Body))
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStream{ReadCloser: f, Size: size, Meta: meta}, nil
+}
+
+// spool copies the content of the reader to a temporary file and returns the file, ready to be read, along with its
+// size. The file is already unlinked: it goes away once it's closed.
+func spool(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "gody-spool-")
+	if err != nil {
+		return nil, 0, errors.Err(err)
+	}
+	_ = os.Remove(f.Name())
+	size, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, errors.Err(err)
+	}
+	return f, size, nil
+}
+
+// objectURL returns the URL of the object, escaping each segment of its name
+func (h *HTTPStore) objectURL(hash string) string {
+	segments := strings.Split(hash, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return h.baseURL + "/" + strings.Join(segments, "/")
+}
+
+// Put is not supported, HTTP and parent origins are read only
+func (h *HTTPStore) Put(hash string, object []byte, extra interface{}) error {
+	return errors.Err("%s origins are read only", h.name)
+}
+
+// PutStream is not supported, HTTP and parent origins are read only
+func (h *HTTPStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	return errors.Err("%s origins are read only", h.name)
+}
+
+// Delete is not supported, HTTP and parent origins are read only
+func (h *HTTPStore) Delete(hash string, extra interface{}) error {
+	return errors.Err("%s origins are read only", h.name)
+}
+
+// Shutdown shuts down the store gracefully
+func (h *HTTPStore) Shutdown() {
+	h.client.CloseIdleConnections()
+}
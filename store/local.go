@@ -0,0 +1,121 @@
+package store
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+)
+
+// LocalStore is a read only store serving objects from a local directory tree (or a mount), the name of the object
+// being its path relative to the root directory. It lets the cache run without any bucket.
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore returns an initialized local store pointer. The root directory must exist.
+func NewLocalStore(root string) (*LocalStore, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	if !info.IsDir() {
+		return nil, errors.Err("%s is not a directory", root)
+	}
+	return &LocalStore{root: root}, nil
+}
+
+const nameLocal = "local"
+
+// Name is the cache type name
+func (l *LocalStore) Name() string { return nameLocal }
+
+// path returns the path of the object. Names are cleaned as absolute paths first so they can't escape the root.
+func (l *LocalStore) path(hash string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+hash)))
+}
+
+// Has returns whether the object exists or not
+func (l *LocalStore) Has(hash string, extra interface{}) (bool, error) {
+	info, err := os.Stat(l.path(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Err(err)
+	}
+	return !info.IsDir(), nil
+}
+
+// Get returns the object or an error if the object doesn't exist
+func (l *LocalStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	start := time.Now()
+	stream, _, err := l.GetStream(hash, extra)
+	if err != nil {
+		return nil, shared.NewBlobTrace(time.Since(start), l.Name()), err
+	}
+	defer stream.Close()
+	object, err := readAll(stream)
+	if err != nil {
+		return nil, shared.NewBlobTrace(time.Since(start), l.Name()), errors.Err(err)
+	}
+	return object, shared.NewBlobTrace(time.Since(start), l.Name()), nil
+}
+
+// GetStream returns the object file opened for reading or an error if the object doesn't exist
+func (l *LocalStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	f, err := os.Open(l.path(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, shared.NewBlobTrace(time.Since(start), l.Name()), errors.Err(ErrObjectNotFound)
+		}
+		return nil, shared.NewBlobTrace(time.Since(start), l.Name()), errors.Err(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, shared.NewBlobTrace(time.Since(start), l.Name()), errors.Err(err)
+	}
+	if info.IsDir() {
+		_ = f.Close()
+		return nil, shared.NewBlobTrace(time.Since(start), l.Name()), errors.Err(ErrObjectNotFound)
+	}
+	// the modification time lets cached copies be revalidated
+	meta := &ObjectMeta{LastModified: info.ModTime().UTC().Truncate(time.Second)}
+	return &ObjectStream{ReadCloser: f, Size: info.Size(), Meta: meta}, shared.NewBlobTrace(time.Since(start), l.Name()), nil
+}
+
+// GetRange returns the object file positioned at the start of the requested range
+func (l *LocalStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	stream, trace, err := l.GetStream(hash, extra)
+	if err != nil {
+		return nil, ContentRange{}, trace, err
+	}
+	cr, err := sliceStream(stream, r)
+	if err != nil {
+		return nil, cr, trace, err
+	}
+	return stream, cr, trace, nil
+}
+
+// Put is not supported, local origins are read only
+func (l *LocalStore) Put(hash string, object []byte, extra interface{}) error {
+	return errors.Err("%s origins are read only", nameLocal)
+}
+
+// PutStream is not supported, local origins are read only
+func (l *LocalStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	return errors.Err("%s origins are read only", nameLocal)
+}
+
+// Delete is not supported, local origins are read only
+func (l *LocalStore) Delete(hash string, extra interface{}) error {
+	return errors.Err("%s origins are read only", nameLocal)
+}
+
+// Shutdown shuts down the store gracefully
+func (l *LocalStore) Shutdown() {}
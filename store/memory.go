@@ -0,0 +1,219 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+	log "github.com/sirupsen/logrus"
+)
+
+// MemoryStore keeps objects in memory up to a total size, evicting the least recently used ones to make room.
+// Objects bigger than maxObjectSize are never kept.
+type MemoryStore struct {
+	maxSize       int64
+	maxObjectSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	objects map[string]*list.Element
+}
+
+type memoryObject struct {
+	hash   string
+	object []byte
+	meta   *ObjectMeta
+	// touchedAt is when the access to the object was last recorded by the store behind the memory tier
+	touchedAt time.Time
+}
+
+// NewMemoryStore returns an initialized memory store pointer.
+func NewMemoryStore(maxSize, maxObjectSize int64) *MemoryStore {
+	return &MemoryStore{
+		maxSize:       maxSize,
+		maxObjectSize: maxObjectSize,
+		lru:           list.New(),
+		objects:       make(map[string]*list.Element),
+	}
+}
+
+const nameMemory = "memory"
+
+// Name is the cache type name
+func (m *MemoryStore) Name() string { return nameMemory }
+
+// Fits returns true if an object of the given size can be kept in memory
+func (m *MemoryStore) Fits(size int64) bool {
+	return size <= m.maxObjectSize && size <= m.maxSize
+}
+
+// Has returns true if the object is in memory
+func (m *MemoryStore) Has(hash string, extra interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.objects[hash]
+	return ok, nil
+}
+
+// Get returns the object or ErrObjectNotFound if it's not in memory.
+// The returned slice is shared and must not be modified.
+func (m *MemoryStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	start := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.objects[hash]
+	if !ok {
+		return nil, shared.NewBlobTrace(time.Since(start), m.Name()), ErrObjectNotFound
+	}
+	m.lru.MoveToFront(e)
+	return e.Value.(*memoryObject).object, shared.NewBlobTrace(time.Since(start), m.Name()), nil
+}
+
+// GetStream returns a stream of the object kept in memory
+func (m *MemoryStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.objects[hash]
+	if !ok {
+		return nil, shared.NewBlobTrace(time.Since(start), m.Name()), ErrObjectNotFound
+	}
+	m.lru.MoveToFront(e)
+	o := e.Value.(*memoryObject)
+	stream := NewObjectStream(o.object)
+	stream.Meta = o.meta
+	return stream, shared.NewBlobTrace(time.Since(start), m.Name()), nil
+}
+
+// Put keeps the object in memory, evicting older objects if needed. Objects that are too big are silently ignored.
+func (m *MemoryStore) Put(hash string, object []byte, extra interface{}) error {
+	m.put(hash, object, nil)
+	return nil
+}
+
+// put keeps the object and its metadata in memory
+func (m *MemoryStore) put(hash string, object []byte, meta *ObjectMeta) {
+	size := int64(len(object))
+	if !m.Fits(size) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.objects[hash]; ok {
+		m.remove(e)
+	}
+	for m.size+size > m.maxSize {
+		m.remove(m.lru.Back())
+	}
+	m.objects[hash] = m.lru.PushFront(&memoryObject{hash: hash, object: object, meta: meta, touchedAt: time.Now()})
+	m.size += size
+}
+
+// PutStream reads the object in memory if it's small enough to be kept
+func (m *MemoryStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	if !m.Fits(object.Size) {
+		return nil
+	}
+	buf, err := readAll(object)
+	if err != nil {
+		return errors.Err(err)
+	}
+	m.put(hash, buf, object.Meta)
+	return nil
+}
+
+// setMeta replaces the metadata of the object if it's in memory
+func (m *MemoryStore) setMeta(hash string, meta *ObjectMeta) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.objects[hash]; ok {
+		e.Value.(*memoryObject).meta = meta
+	}
+}
+
+// touchDue returns true if the object is in memory and its last access was recorded longer than interval ago, in
+// which case it's considered recorded now
+func (m *MemoryStore) touchDue(hash string, interval time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.objects[hash]
+	if !ok {
+		return false
+	}
+	o := e.Value.(*memoryObject)
+	if time.Since(o.touchedAt) < interval {
+		return false
+	}
+	o.touchedAt = time.Now()
+	return true
+}
+
+// Delete removes the object from memory
+func (m *MemoryStore) Delete(hash string, extra interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.objects[hash]; ok {
+		m.remove(e)
+	}
+	return nil
+}
+
+// remove must be called with the lock held
+func (m *MemoryStore) remove(e *list.Element) {
+	o := e.Value.(*memoryObject)
+	m.lru.Remove(e)
+	delete(m.objects, o.hash)
+	m.size -= int64(len(o.object))
+}
+
+// Shutdown shuts down the store gracefully
+func (m *MemoryStore) Shutdown() {
+}
+
+// WithHotTier puts a memory store in front of the cache. Small objects that are read from the cache are kept
+// in memory too, so the hottest ones (playlists, init segments) are served without touching the db or the disk.
+// Objects coming from the origin aren't kept in memory until they're requested again.
+func (c *CachingStore) WithHotTier(hot *MemoryStore) *CachingStore {
+	c.hot = hot
+	return c
+}
+
+// promote copies a stream coming from the cache into the hot tier if it's small enough, returning a stream of the
+// in memory copy in that case. The original stream is closed if it's consumed.
+func (c *CachingStore) promote(hashedName string, stream *ObjectStream, extra interface{}) (*ObjectStream, error) {
+	if c.hot == nil || !c.hot.Fits(stream.Size) {
+		return stream, nil
+	}
+	defer stream.Close()
+	object, err := readAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	c.hot.put(hashedName, object, stream.Meta)
+	promoted := NewObjectStream(object)
+	promoted.Meta = stream.Meta
+	return promoted, nil
+}
+
+// toucher is implemented by stores keeping track of when their objects were last accessed
+type toucher interface {
+	Touch(hash string) error
+}
+
+// touchHot records in the cache the access to an object served from the hot tier, at most once every refreshInterval
+// like the cache does, so that the hottest objects aren't the first ones to be evicted from the disk
+func (c *CachingStore) touchHot(hashedName string) {
+	t, ok := unwrap(c.cache).(toucher)
+	if !ok || !c.hot.touchDue(hashedName, refreshInterval) {
+		return
+	}
+	go func() {
+		err := t.Touch(hashedName)
+		if err != nil {
+			log.Errorf("error recording the access to %s: %s", hashedName, errors.FullTrace(err))
+		}
+	}()
+}
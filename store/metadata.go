@@ -0,0 +1,211 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// MetadataStore keeps track of the objects held by a DBBackedStore: their size, when they were last accessed
+// and their checksum. It's what the cleanup relies on to decide what to evict.
+type MetadataStore interface {
+	// Get returns the record of the object or nil if the object isn't tracked
+	Get(hash string) (*ObjectRecord, error)
+	// Insert starts tracking the object, replacing any previous record with the same hash
+	Insert(record ObjectRecord) error
+	// Touch sets the last access time of the object
+	Touch(hash string, at time.Time) error
+	// UpdateMeta replaces what the origin told about the object
+	UpdateMeta(hash string, meta *ObjectMeta) error
+	// Delete stops tracking the object
+	Delete(hash string) error
+	// UsedSpace returns the total length of the tracked objects
+	UsedSpace() (int64, error)
+	// LeastRecentlyAccessed returns up to limit records starting from the least recently accessed one, skipping the first offset records
+	LeastRecentlyAccessed(offset, limit int) ([]ObjectRecord, error)
+	// List returns up to limit records in hash order, starting after the given hash
+	List(afterHash string, limit int) ([]ObjectRecord, error)
+	// ListByName returns up to limit records of the objects whose name starts with prefix, in name and then hash order,
+	// starting after the record with the given name and hash. Objects of unknown name are never returned.
+	ListByName(prefix, afterName, afterHash string, limit int) ([]ObjectRecord, error)
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// ObjectRecord is what's known about a tracked object
+type ObjectRecord struct {
+	Hash           string
+	Length         int64
+	LastAccessedAt time.Time
+	// Checksum is nil for objects cached before checksums were introduced
+	Checksum *uint32
+	// Meta is what the origin told about the object, it's only loaded by Get
+	Meta *ObjectMeta
+	// Name is the original name of the object, empty if unknown. It's only loaded by Get and ListByName.
+	Name string
+	// Origin is the index of the origin the object came from, nil if unknown. It's only loaded by Get and ListByName.
+	Origin *int
+}
+
+// sqlMetadata is a MetadataStore for SQL databases. The queries that differ between databases are set by the constructors.
+type sqlMetadata struct {
+	conn *sql.DB
+	// reads is the pool used for the queries that only read, it's conn itself unless the database needs them apart
+	reads *sql.DB
+	// insertQuery inserts or replaces a record, taking hash, length, last_accessed_at, checksum, meta, name and origin
+	// as parameters
+	insertQuery string
+}
+
+const recordColumns = `hash, length, last_accessed_at, checksum`
+
+// lastRune sorts after any character that can follow a prefix, so that names starting with the prefix sort below
+// the prefix followed by it
+const lastRune = "\U0010FFFF"
+
+func (s *sqlMetadata) Get(hash string) (*ObjectRecord, error) {
+	row := s.reads.QueryRow(`SELECT `+recordColumns+`, meta, name, origin FROM object WHERE hash = ? AND is_stored = 1`, hash)
+	var meta, name sql.NullString
+	var origin sql.NullInt64
+	record, err := scanRecord(row, &meta, &name, &origin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Err(err)
+	}
+	record.Meta = unmarshalMeta(meta)
+	record.setOrigin(name, origin)
+	return record, nil
+}
+
+func (s *sqlMetadata) Insert(record ObjectRecord) error {
+	var checksum interface{}
+	if record.Checksum != nil {
+		checksum = *record.Checksum
+	}
+	var name, origin interface{}
+	if record.Name != "" {
+		name = record.Name
+	}
+	if record.Origin != nil {
+		origin = *record.Origin
+	}
+	_, err := s.conn.Exec(s.insertQuery, record.Hash, record.Length, record.LastAccessedAt.UTC(), checksum, marshalMeta(record.Meta), name, origin)
+	return errors.Err(err)
+}
+
+func (s *sqlMetadata) Touch(hash string, at time.Time) error {
+	_, err := s.conn.Exec(`UPDATE object SET last_accessed_at = ? WHERE hash = ?`, at.UTC(), hash)
+	return errors.Err(err)
+}
+
+func (s *sqlMetadata) UpdateMeta(hash string, meta *ObjectMeta) error {
+	_, err := s.conn.Exec(`UPDATE object SET meta = ? WHERE hash = ?`, marshalMeta(meta), hash)
+	return errors.Err(err)
+}
+
+func (s *sqlMetadata) Delete(hash string) error {
+	_, err := s.conn.Exec(`DELETE FROM object WHERE hash = ?`, hash)
+	return errors.Err(err)
+}
+
+// UsedSpace doesn't filter on is_stored: it's always 1 in the current implementation and the query is a lot faster
+// without the filter
+func (s *sqlMetadata) UsedSpace() (int64, error) {
+	var total sql.NullInt64
+	err := s.reads.QueryRow(`SELECT SUM(length) FROM object`).Scan(&total)
+	if err != nil {
+		return 0, errors.Err(err)
+	}
+	return total.Int64, nil
+}
+
+func (s *sqlMetadata) LeastRecentlyAccessed(offset, limit int) ([]ObjectRecord, error) {
+	return s.query(`SELECT `+recordColumns+` FROM object ORDER BY last_accessed_at LIMIT ? OFFSET ?`, limit, offset)
+}
+
+func (s *sqlMetadata) List(afterHash string, limit int) ([]ObjectRecord, error) {
+	return s.query(`SELECT `+recordColumns+` FROM object WHERE hash > ? ORDER BY hash LIMIT ?`, afterHash, limit)
+}
+
+// ListByName compares names as ranges rather than with LIKE so that the index on the name is used the same way by
+// every database, and no character of the prefix has to be escaped
+func (s *sqlMetadata) ListByName(prefix, afterName, afterHash string, limit int) ([]ObjectRecord, error) {
+	rows, err := s.reads.Query(`SELECT `+recordColumns+`, name, origin FROM object WHERE name >= ? AND name < ? `+
+		`AND (name > ? OR (name = ? AND hash > ?)) ORDER BY name, hash LIMIT ?`,
+		prefix, prefix+lastRune, afterName, afterName, afterHash, limit)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	defer rows.Close()
+	var records []ObjectRecord
+	for rows.Next() {
+		var name sql.NullString
+		var origin sql.NullInt64
+		record, err := scanRecord(rows, &name, &origin)
+		if err != nil {
+			return nil, errors.Err(err)
+		}
+		record.setOrigin(name, origin)
+		records = append(records, *record)
+	}
+	return records, errors.Err(rows.Err())
+}
+
+func (s *sqlMetadata) Close() error {
+	if s.reads != s.conn {
+		_ = s.reads.Close()
+	}
+	return errors.Err(s.conn.Close())
+}
+
+func (s *sqlMetadata) query(query string, args ...interface{}) ([]ObjectRecord, error) {
+	rows, err := s.reads.Query(query, args...)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	defer rows.Close()
+	var records []ObjectRecord
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, errors.Err(err)
+		}
+		records = append(records, *record)
+	}
+	return records, errors.Err(rows.Err())
+}
+
+// rowScanner is either a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRecord reads a record selected with recordColumns, followed by the extra columns if any
+func scanRecord(row rowScanner, extra ...interface{}) (*ObjectRecord, error) {
+	var record ObjectRecord
+	var length, checksum sql.NullInt64
+	var lastAccess sql.NullTime
+	err := row.Scan(append([]interface{}{&record.Hash, &length, &lastAccess, &checksum}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	record.Length = length.Int64
+	record.LastAccessedAt = lastAccess.Time
+	if checksum.Valid {
+		c := uint32(checksum.Int64)
+		record.Checksum = &c
+	}
+	return &record, nil
+}
+
+// setOrigin sets the name and origin of the record from their columns
+func (r *ObjectRecord) setOrigin(name sql.NullString, origin sql.NullInt64) {
+	r.Name = name.String
+	if origin.Valid {
+		o := int(origin.Int64)
+		r.Origin = &o
+	}
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"database/sql"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+/*
+Table description:
+CREATE TABLE `object`
+(
+    `id`               bigint unsigned                  NOT NULL AUTO_INCREMENT,
+    `hash`             char(64) COLLATE utf8_unicode_ci NOT NULL,
+    `is_stored`        tinyint(1)                       NOT NULL DEFAULT '0',
+    `length`           bigint unsigned                           DEFAULT NULL,
+    `last_accessed_at` timestamp                        NULL     DEFAULT NULL,
+    `checksum`         int unsigned                              DEFAULT NULL,
+    `meta`             text                                      DEFAULT NULL,
+    `name`             varchar(1024) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin DEFAULT NULL,
+    `origin`           int                                       DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `id` (`id`),
+    UNIQUE KEY `hash_idx` (`hash`),
+    KEY `last_accessed_idx` (`last_accessed_at`),
+    KEY `is_stored_idx` (`is_stored`),
+    KEY `name_idx` (`name`(255))
+);
+*/
+
+// NewMySQLMetadata returns a metadata store backed by the MySQL database at dsn. The table has to exist already.
+func NewMySQLMetadata(dsn string) (MetadataStore, error) {
+	conn, err := connect(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlMetadata{
+		conn:  conn,
+		reads: conn,
+		insertQuery: `INSERT INTO object (hash,length,last_accessed_at,checksum,meta,name,origin,is_stored) VALUES(?,?,?,?,?,?,?,1) ON DUPLICATE KEY UPDATE ` +
+			`is_stored = 1, length = VALUES(length), last_accessed_at = VALUES(last_accessed_at), checksum = VALUES(checksum), meta = VALUES(meta), ` +
+			`name = VALUES(name), origin = VALUES(origin)`,
+	}, nil
+}
+
+// Connect will create a connection to the database
+func connect(dsn string) (*sql.DB, error) {
+	var err error
+	dsn += "?parseTime=1&collation=utf8mb4_unicode_ci"
+	conn, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+
+	conn.SetMaxIdleConns(12)
+
+	return conn, errors.Err(conn.Ping())
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"database/sql"
+	"runtime"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS object
+(
+    hash             TEXT    NOT NULL PRIMARY KEY,
+    is_stored        INTEGER NOT NULL DEFAULT 0,
+    length           INTEGER          DEFAULT NULL,
+    last_accessed_at TIMESTAMP        DEFAULT NULL,
+    checksum         INTEGER          DEFAULT NULL,
+    meta             TEXT             DEFAULT NULL,
+    name             TEXT             DEFAULT NULL,
+    origin           INTEGER          DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS last_accessed_idx ON object (last_accessed_at);
+`
+
+// NewSQLiteMetadata returns a metadata store backed by an embedded SQLite database kept in the file at path,
+// so that no database server is needed. The schema is created if needed.
+func NewSQLiteMetadata(path string) (MetadataStore, error) {
+	// timestamps are always stored in UTC so that they sort correctly as text
+	options := "_pragma=busy_timeout(10000)&_time_format=sqlite"
+	conn, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&"+options)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	// SQLite only allows one writer at a time, a single connection avoids fighting over the lock
+	conn.SetMaxOpenConns(1)
+	_, err = conn.Exec(sqliteSchema)
+	if err == nil {
+		err = migrateSQLite(conn)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Err(err)
+	}
+	// in WAL mode readers don't block the writer nor each other, so they get a pool of their own
+	reads, err := sql.Open("sqlite", "file:"+path+"?mode=ro&"+options)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Err(err)
+	}
+	reads.SetMaxOpenConns(runtime.NumCPU())
+	return &sqlMetadata{
+		conn:  conn,
+		reads: reads,
+		insertQuery: `INSERT INTO object (hash,length,last_accessed_at,checksum,meta,name,origin,is_stored) VALUES(?,?,?,?,?,?,?,1) ON CONFLICT(hash) DO UPDATE SET ` +
+			`is_stored = 1, length = excluded.length, last_accessed_at = excluded.last_accessed_at, checksum = excluded.checksum, meta = excluded.meta, ` +
+			`name = excluded.name, origin = excluded.origin`,
+	}, nil
+}
+
+// sqliteColumns are the columns added to the object table after it was first introduced, with their definition
+var sqliteColumns = []struct{ name, definition string }{
+	{"meta", "TEXT DEFAULT NULL"},
+	{"name", "TEXT DEFAULT NULL"},
+	{"origin", "INTEGER DEFAULT NULL"},
+}
+
+// sqliteIndexes are the indexes on the columns added after the table was first introduced
+const sqliteIndexes = `
+CREATE INDEX IF NOT EXISTS name_idx ON object (name, hash);
+`
+
+// migrateSQLite adds the columns and indexes missing from databases created by older versions
+func migrateSQLite(conn *sql.DB) error {
+	for _, column := range sqliteColumns {
+		var count int
+		err := conn.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('object') WHERE name = ?`, column.name).Scan(&count)
+		if err != nil {
+			return errors.Err(err)
+		}
+		if count > 0 {
+			continue
+		}
+		_, err = conn.Exec(`ALTER TABLE object ADD COLUMN ` + column.name + ` ` + column.definition)
+		if err != nil {
+			return errors.Err(err)
+		}
+	}
+	_, err := conn.Exec(sqliteIndexes)
+	return errors.Err(err)
+}
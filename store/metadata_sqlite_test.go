@@ -0,0 +1,250 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newSQLiteMetadata returns a metadata store kept in a temporary SQLite db
+func newSQLiteMetadata(t *testing.T) MetadataStore {
+	meta, err := NewSQLiteMetadata(filepath.Join(t.TempDir(), "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = meta.Close() })
+	return meta
+}
+
+// testRecord returns the record of an object named after its hash, fetched from the first origin and last accessed at
+// the given minute of the test day
+func testRecord(hash string, length int64, minute int) ObjectRecord {
+	checksum := uint32(length)
+	origin := 0
+	return ObjectRecord{
+		Hash:           hash,
+		Length:         length,
+		LastAccessedAt: time.Date(2024, 3, 1, 12, minute, 0, 0, time.UTC),
+		Checksum:       &checksum,
+		Meta:           &ObjectMeta{ContentType: "video/mp2t", ETag: `"` + hash + `"`},
+		Name:           "videos/" + hash,
+		Origin:         &origin,
+	}
+}
+
+func TestSQLiteMetadata(t *testing.T) {
+	m := newSQLiteMetadata(t)
+	record := testRecord("a", 10, 0)
+	if err := m.Insert(record); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameRecord(got, &record) {
+		t.Fatalf("expected %+v, got %+v", record, got)
+	}
+	if got, err := m.Get("missing"); got != nil || err != nil {
+		t.Fatalf("expected no record, got %+v (%v)", got, err)
+	}
+
+	// inserting again replaces the record, records of objects cached before checksums and names have neither
+	replaced := ObjectRecord{Hash: "a", Length: 20, LastAccessedAt: record.LastAccessedAt}
+	if err := m.Insert(replaced); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.Get("a"); err != nil || !sameRecord(got, &replaced) {
+		t.Fatalf("expected %+v, got %+v (%v)", replaced, got, err)
+	}
+
+	accessed := record.LastAccessedAt.Add(time.Hour)
+	if err := m.Touch("a", accessed); err != nil {
+		t.Fatal(err)
+	}
+	validated := &ObjectMeta{ETag: `"a"`, ValidatedAt: accessed}
+	if err := m.UpdateMeta("a", validated); err != nil {
+		t.Fatal(err)
+	}
+	got, err = m.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.LastAccessedAt.Equal(accessed) || !reflect.DeepEqual(got.Meta, validated) {
+		t.Errorf("expected an access at %s and %+v, got %+v", accessed, validated, got)
+	}
+
+	if err := m.Insert(testRecord("b", 5, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := m.UsedSpace(); used != 25 || err != nil {
+		t.Errorf("expected 25 bytes used, got %d (%v)", used, err)
+	}
+	if err := m.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.Get("a"); got != nil || err != nil {
+		t.Errorf("expected no record once deleted, got %+v (%v)", got, err)
+	}
+	if used, err := m.UsedSpace(); used != 5 || err != nil {
+		t.Errorf("expected 5 bytes used, got %d (%v)", used, err)
+	}
+}
+
+func TestSQLiteLeastRecentlyAccessed(t *testing.T) {
+	m := newSQLiteMetadata(t)
+	// inserted out of order, accessed from e (least recently) to a
+	for _, r := range []struct {
+		hash   string
+		minute int
+	}{{"c", 3}, {"a", 5}, {"e", 1}, {"b", 4}, {"d", 2}} {
+		if err := m.Insert(testRecord(r.hash, 1, r.minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a touched object is the most recently accessed one
+	if err := m.Touch("e", time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		offset, limit int
+		expected      []string
+	}{
+		{0, 10, []string{"d", "c", "b", "a", "e"}},
+		{0, 2, []string{"d", "c"}},
+		{2, 2, []string{"b", "a"}},
+		{4, 2, []string{"e"}},
+		{5, 2, nil},
+	}
+	for _, tt := range tests {
+		records, err := m.LeastRecentlyAccessed(tt.offset, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := recordHashes(records); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("offset %d, limit %d: expected %v, got %v", tt.offset, tt.limit, tt.expected, got)
+		}
+	}
+}
+
+func TestSQLiteListByName(t *testing.T) {
+	m := newSQLiteMetadata(t)
+	records := map[string]string{
+		"1": "videos/a/1.ts",
+		"2": "videos/a/1.ts",
+		"3": "videos/a/2.ts",
+		"4": "videos/ab.ts",
+		"5": "videos/b.ts",
+		"6": "",
+	}
+	for hash, name := range records {
+		record := testRecord(hash, 1, 0)
+		record.Name = name
+		if err := m.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name                 string
+		prefix               string
+		afterName, afterHash string
+		limit                int
+		expected             []string
+	}{
+		{"everything named", "", "", "", 10, []string{"1", "2", "3", "4", "5"}},
+		{"prefix", "videos/a", "", "", 10, []string{"1", "2", "3", "4"}},
+		{"directory", "videos/a/", "", "", 10, []string{"1", "2", "3"}},
+		{"exact name", "videos/a/1.ts", "", "", 10, []string{"1", "2"}},
+		{"limit", "videos/a", "", "", 2, []string{"1", "2"}},
+		{"after a name", "videos/a", "videos/a/1.ts", "2", 10, []string{"3", "4"}},
+		{"within a name", "videos/a", "videos/a/1.ts", "1", 10, []string{"2", "3", "4"}},
+		{"no match", "videos/c", "", "", 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := m.ListByName(tt.prefix, tt.afterName, tt.afterHash, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := recordHashes(records); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSQLiteMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	// the schema before the metadata, the name and the origin of objects were recorded
+	conn, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`
+CREATE TABLE object
+(
+    hash             TEXT    NOT NULL PRIMARY KEY,
+    is_stored        INTEGER NOT NULL DEFAULT 0,
+    length           INTEGER          DEFAULT NULL,
+    last_accessed_at TIMESTAMP        DEFAULT NULL,
+    checksum         INTEGER          DEFAULT NULL
+);
+CREATE INDEX last_accessed_idx ON object (last_accessed_at);
+INSERT INTO object (hash, is_stored, length, last_accessed_at, checksum) VALUES ('old', 1, 10, '2024-03-01 12:00:00+00:00', 42);
+`)
+	_ = conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewSQLiteMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	old, err := m.Get("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := uint32(42)
+	expected := &ObjectRecord{Hash: "old", Length: 10, LastAccessedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Checksum: &checksum}
+	if !sameRecord(old, expected) {
+		t.Errorf("expected %+v, got %+v", expected, old)
+	}
+
+	record := testRecord("new", 5, 0)
+	if err := m.Insert(record); err != nil {
+		t.Fatal(err)
+	}
+	records, err := m.ListByName("videos/", "", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recordHashes(records); !reflect.DeepEqual(got, []string{"new"}) || records[0].Origin == nil {
+		t.Errorf("expected the new record with its origin, got %+v", records)
+	}
+}
+
+// sameRecord returns true if the records are the same, wherever their access times are expressed
+func sameRecord(got, expected *ObjectRecord) bool {
+	if got == nil || expected == nil {
+		return got == expected
+	}
+	if !got.LastAccessedAt.Equal(expected.LastAccessedAt) {
+		return false
+	}
+	g := *got
+	g.LastAccessedAt = expected.LastAccessedAt
+	return reflect.DeepEqual(&g, expected)
+}
+
+// recordHashes returns the hashes of the records, in order
+func recordHashes(records []ObjectRecord) []string {
+	var hashes []string
+	for _, record := range records {
+		hashes = append(hashes, record.Hash)
+	}
+	return hashes
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"github.com/OdyseeTeam/gody-cdn/hashring"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+	log "github.com/sirupsen/logrus"
+)
+
+// MultiDiskStore spreads objects over several disks using consistent hashing on the object hash.
+// Disks are identified by their path so the placement of objects doesn't change when the list is reordered,
+// and a disk that's removed (or fails to initialize) only loses the objects it held.
+type MultiDiskStore struct {
+	disks map[string]*DiskStore
+	ring  *hashring.Ring
+}
+
+// NewMultiDiskStore returns an initialized multi disk store pointer. Disks that can't be initialized are left
+// out with an error in the logs, it's only an error if none of them are usable.
+func NewMultiDiskStore(dirs []string, prefixLength int) (*MultiDiskStore, error) {
+	ms := &MultiDiskStore{disks: make(map[string]*DiskStore, len(dirs))}
+	members := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		ds, err := NewDiskStore(dir, prefixLength)
+		if err != nil {
+			log.Errorf("leaving disk %s out of the cache: %s", dir, errors.FullTrace(err))
+			continue
+		}
+		ms.disks[dir] = ds
+		members = append(members, dir)
+	}
+	if len(members) == 0 {
+		return nil, errors.Err("none of the %d disks are usable", len(dirs))
+	}
+	ms.ring = hashring.New(members, hashring.DefaultReplicas)
+	return ms, nil
+}
+
+const nameMultiDisk = "multi-disk"
+
+// Name is the cache type name
+func (m *MultiDiskStore) Name() string { return nameMultiDisk }
+
+// DiskFor returns the path of the disk the object belongs to
+func (m *MultiDiskStore) DiskFor(hash string) string {
+	return m.ring.Get(hash)
+}
+
+func (m *MultiDiskStore) disk(hash string) *DiskStore {
+	return m.disks[m.ring.Get(hash)]
+}
+
+// Has returns whether the object exists on its disk
+func (m *MultiDiskStore) Has(hash string, extra interface{}) (bool, error) {
+	return m.disk(hash).Has(hash, extra)
+}
+
+// Get returns the object from its disk
+func (m *MultiDiskStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	return m.disk(hash).Get(hash, extra)
+}
+
+// GetStream returns the object file from its disk
+func (m *MultiDiskStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	return m.disk(hash).GetStream(hash, extra)
+}
+
+// GetRange returns a range of the object file from its disk
+func (m *MultiDiskStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	return m.disk(hash).GetRange(hash, r, extra)
+}
+
+// Put stores the object on its disk
+func (m *MultiDiskStore) Put(hash string, object []byte, extra interface{}) error {
+	return m.disk(hash).Put(hash, object, extra)
+}
+
+// PutStream streams the object on its disk
+func (m *MultiDiskStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	return m.disk(hash).PutStream(hash, object, extra)
+}
+
+// Delete deletes the object from its disk
+func (m *MultiDiskStore) Delete(hash string, extra interface{}) error {
+	return m.disk(hash).Delete(hash, extra)
+}
+
+// Quarantine moves the object into the quarantine directory of its disk
+func (m *MultiDiskStore) Quarantine(hash string) error {
+	return m.disk(hash).Quarantine(hash)
+}
+
+// Walk calls fn for every object on every disk. Objects that are on a different disk than the one the ring assigns
+// them to, which happens when the list of disks changes, are reported as misplaced.
+func (m *MultiDiskStore) Walk(fn func(object StoredObject) error) error {
+	for dir, d := range m.disks {
+		err := d.Walk(func(object StoredObject) error {
+			object.Misplaced = object.Misplaced || m.DiskFor(object.Hash) != dir
+			return fn(object)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown shuts down the store gracefully
+func (m *MultiDiskStore) Shutdown() {
+	for _, d := range m.disks {
+		d.Shutdown()
+	}
+}
@@ -1,51 +1,154 @@
 package store
 
 import (
-	"bytes"
-	"net/http"
+	"fmt"
 	"time"
 
 	"github.com/OdyseeTeam/gody-cdn/configs"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/lbryio/lbry.go/v2/extras/errors"
 	"github.com/lbryio/reflector.go/shared"
 	log "github.com/sirupsen/logrus"
 )
 
-// MultiS3Store is a collection of S3 stores
+// MultiS3Store is a collection of origins: S3 buckets, and despite the name, HTTP servers, parent nodes and local
+// directories. Reads go to the origin selected by the extra params and fail over to its fallbacks when the object is
+// missing or the origin is unavailable.
 type MultiS3Store struct {
-	instances []s3Instance
-}
-type s3Instance struct {
-	config  configs.S3Configs
-	session session.Session
+	instances []ObjectStore
+	// locations describes where each origin is, for logs and status
+	locations []string
+	names     []string
+	// chains holds, for each origin, the indexes of the origins to try in order, starting with the origin itself
+	chains [][]int
+	// breakers holds the circuit breaker of each origin, it's nil when the breakers are disabled
+	breakers []*CircuitBreaker
+	// policies holds the retry policy of each origin
+	policies []RetryPolicy
 }
 
 // NewMultiS3Store returns an initialized S3 store pointer.
 func NewMultiS3Store(configs []configs.S3Configs) (*MultiS3Store, error) {
 	var ms MultiS3Store
-	// we need to access the configs via index because session.NewSession does NOT copy the value of configs, rather stores the pointer only.
+	indexes := make(map[string]int)
 	for i := range configs {
-		sess, err := session.NewSession(configs[i].GetS3AWSConfig())
+		instance, location, err := newOrigin(configs[i], i)
 		if err != nil {
-			return nil, errors.Err(err)
+			return nil, err
+		}
+		ms.instances = append(ms.instances, instance)
+		ms.locations = append(ms.locations, location)
+		ms.names = append(ms.names, configs[i].Name)
+		ms.policies = append(ms.policies, retryPolicy(configs[i].Retry))
+		if configs[i].Name != "" {
+			indexes[configs[i].Name] = i
+		}
+	}
+	for i := range configs {
+		chain := []int{i}
+		for _, name := range configs[i].Fallbacks {
+			fallback, ok := indexes[name]
+			if !ok {
+				return nil, errors.Err("origin %s has an unknown fallback %s", configs[i].Name, name)
+			}
+			if fallback == i {
+				continue
+			}
+			chain = append(chain, fallback)
 		}
-		ms.instances = append(ms.instances, s3Instance{
-			config:  configs[i],
-			session: *sess,
-		})
+		ms.chains = append(ms.chains, chain)
 	}
 
 	return &ms, nil
 }
 
+// newOrigin returns the store for the origin at the given index along with where it is
+func newOrigin(config configs.S3Configs, index int) (ObjectStore, string, error) {
+	switch config.GetType() {
+	case configs.OriginS3:
+		instance := NewS3Store(config)
+		// sessions are created upfront so that a bad configuration is caught at startup
+		err := instance.initOnce()
+		if err != nil {
+			return nil, "", err
+		}
+		return instance, config.Bucket, nil
+	case configs.OriginHTTP:
+		if config.URL == "" {
+			return nil, "", errors.Err("%s origin %s requires a url", configs.OriginHTTP, config.Name)
+		}
+		return NewHTTPStore(config.URL, config.Headers, config.GetTimeout(), config.NotFoundStatuses), config.URL, nil
+	case configs.OriginParent:
+		if config.URL == "" {
+			return nil, "", errors.Err("%s origin %s requires a url", configs.OriginParent, config.Name)
+		}
+		parentOrigin := config.ParentOrigin
+		if parentOrigin == "" {
+			parentOrigin = configs.OriginName(index, config)
+		}
+		return NewParentStore(config.URL, config.Headers, config.GetTimeout(), parentOrigin), config.URL, nil
+	case configs.OriginLocal:
+		if config.Path == "" {
+			return nil, "", errors.Err("%s origin %s requires a path", configs.OriginLocal, config.Name)
+		}
+		instance, err := NewLocalStore(config.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		return instance, config.Path, nil
+	default:
+		return nil, "", errors.Err("origin %s has an unknown type %s", config.Name, config.Type)
+	}
+}
+
+// retryPolicy returns the retry policy described by the configuration. Without one the S3 client does its own retries.
+func retryPolicy(params configs.RetryParams) RetryPolicy {
+	if !params.Enabled() {
+		return RetryPolicy{}
+	}
+	return RetryPolicy{
+		MaxAttempts: params.MaxAttempts,
+		BaseDelay:   params.GetBaseDelay(),
+		MaxDelay:    params.GetMaxDelay(),
+		Deadline:    params.GetDeadline(),
+	}
+}
+
+// WithCircuitBreakers puts a circuit breaker in front of every origin. Requests to an origin whose breaker is open
+// fail right away with ErrOriginUnavailable, or go to the next origin of the fallback chain.
+func (s *MultiS3Store) WithCircuitBreakers(settings BreakerSettings) *MultiS3Store {
+	s.breakers = make([]*CircuitBreaker, len(s.instances))
+	for i := range s.instances {
+		s.breakers[i] = NewCircuitBreaker(settings)
+	}
+	return s
+}
+
+// OriginStatus describes the health of an origin
+type OriginStatus struct {
+	Name     string         `json:"name"`
+	Location string         `json:"location"`
+	Breaker  *BreakerStatus `json:"breaker,omitempty"`
+}
+
+// Status returns the health of every origin
+func (s *MultiS3Store) Status() []OriginStatus {
+	statuses := make([]OriginStatus, 0, len(s.instances))
+	for i := range s.instances {
+		status := OriginStatus{Name: s.names[i], Location: s.locations[i]}
+		if s.breakers != nil {
+			breakerStatus := s.breakers[i].Status()
+			status.Breaker = &breakerStatus
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
 type MultiS3Extras struct {
 	S3Index int
+	// FromPeer is set when the request comes from a sibling node, so that it isn't forwarded to the peers again
+	FromPeer bool
 }
 
 const nameMultiS3 = "multiS3"
@@ -53,97 +156,185 @@ const nameMultiS3 = "multiS3"
 // Name is the cache type name
 func (s *MultiS3Store) Name() string { return nameMultiS3 }
 
-// Has returns T/F or Error ( from S3 ) if the store contains the object.
+// Has returns T/F or Error ( from the origin ) if the store contains the object.
 func (s *MultiS3Store) Has(hash string, extra interface{}) (bool, error) {
-	ex := s.getExtras(extra)
-	if ex == nil {
-		return false, errors.Err("%s requires an origin index to be specified in the extra params. use the MultiS3Extras struct.", nameMultiS3)
-	}
-	_, err := s3.New(&s.instances[ex.S3Index].session).HeadObject(&s3.HeadObjectInput{
-		Bucket: aws.String(s.instances[ex.S3Index].config.Bucket),
-		Key:    aws.String(hash),
-	})
-	if err != nil {
-		if reqFail, ok := err.(s3.RequestFailure); ok && reqFail.StatusCode() == http.StatusNotFound {
-			return false, nil
+	var has bool
+	_, err := s.failover(extra, func(instance ObjectStore, location string) error {
+		var err error
+		has, err = instance.Has(hash, extra)
+		if err == nil && !has {
+			return errors.Err(ErrObjectNotFound)
 		}
-		return false, err
+		return err
+	})
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
 	}
-
-	return true, nil
+	return has, err
 }
 
-// Get returns the object slice if present or errors on S3.
+// Get returns the object slice if present or errors on the origin.
 func (s *MultiS3Store) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
-	start := time.Now()
-	ex := s.getExtras(extra)
-	if ex == nil {
-		return nil, shared.NewBlobTrace(time.Since(start), s.Name()), errors.Err("%s requires an origin index to be specified in the extra params. use the MultiS3Extras struct.", nameMultiS3)
+	// the object is streamed so that the breakers time how long the origin takes to answer, not the download
+	stream, trace, err := s.GetStream(hash, extra)
+	if err != nil {
+		return nil, trace, err
 	}
-	truncatedHash := hash
-	if len(hash) > 8 {
-		truncatedHash = hash[:8]
+	defer stream.Close()
+	object, err := readAll(stream)
+	if err != nil {
+		return nil, trace, err
 	}
-	log.Debugf("Getting %s from S3 at index %d", truncatedHash, ex.S3Index)
-	defer func(t time.Time) {
-		log.Debugf("Getting %s from S3 took %s", truncatedHash, time.Since(t).String())
-	}(start)
+	return object, trace, nil
+}
+
+// GetStream returns the object as it's being downloaded from the origin.
+func (s *MultiS3Store) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	var stream *ObjectStream
+	var trace shared.BlobTrace
+	retries, err := s.failover(extra, func(instance ObjectStore, location string) error {
+		log.Debugf("Streaming %s from %s", truncate(hash), location)
+		var err error
+		stream, trace, err = GetStream(instance, hash, extra)
+		return err
+	})
+	return stream, trace.Stack(time.Since(start), s.traceName(retries)), err
+}
+
+// GetRange returns a range of the object as it's being downloaded from the origin.
+func (s *MultiS3Store) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	start := time.Now()
+	var stream *ObjectStream
+	var cr ContentRange
+	var trace shared.BlobTrace
+	retries, err := s.failover(extra, func(instance ObjectStore, location string) error {
+		log.Debugf("Streaming %s of %s from %s", r.String(), truncate(hash), location)
+		var err error
+		stream, cr, trace, err = GetRange(instance, hash, r, extra)
+		return err
+	})
+	return stream, cr, trace.Stack(time.Since(start), s.traceName(retries)), err
+}
 
-	buf := &aws.WriteAtBuffer{}
-	_, err := s3manager.NewDownloader(&s.instances[ex.S3Index].session).Download(buf, &s3.GetObjectInput{
-		Bucket: aws.String(s.instances[ex.S3Index].config.Bucket),
-		Key:    aws.String(hash),
+// Revalidate asks the origin whether the copy of the object described by meta is still current
+func (s *MultiS3Store) Revalidate(hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error) {
+	start := time.Now()
+	var current *ObjectMeta
+	var size int64
+	var trace shared.BlobTrace
+	retries, err := s.failover(extra, func(instance ObjectStore, location string) error {
+		log.Debugf("Revalidating %s on %s", truncate(hash), location)
+		var err error
+		current, size, trace, err = Revalidate(instance, hash, meta, extra)
+		return err
 	})
+	return current, size, trace.Stack(time.Since(start), s.traceName(retries)), err
+}
+
+// failover calls attempt with the origin selected by the extra params, then with each of its fallbacks for as long as
+// the object is missing or the origin is unavailable. It returns how many retries were made in total along with
+// the error of the last attempt.
+func (s *MultiS3Store) failover(extra interface{}, attempt func(instance ObjectStore, location string) error) (int, error) {
+	index, err := s.getIndex(extra)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-				return nil, shared.NewBlobTrace(time.Since(start), s.Name()), errors.Err("bucket %s does not exist", s.instances[ex.S3Index].config.Bucket)
-			case s3.ErrCodeNoSuchKey:
-				return nil, shared.NewBlobTrace(time.Since(start), s.Name()), errors.Err(ErrObjectNotFound)
-			}
+		return 0, err
+	}
+	total := 0
+	for i, candidate := range s.chains[index] {
+		var retries int
+		retries, err = s.call(candidate, true, true, attempt)
+		total += retries
+		if err == nil || !(errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrOriginUnavailable) || isTransientError(err)) {
+			return total, err
 		}
+		if i < len(s.chains[index])-1 {
+			log.Debugf("origin %s failed (%s), failing over", s.locations[candidate], err.Error())
+		}
+	}
+	return total, err
+}
+
+// call calls attempt with the origin at index unless its circuit breaker is open, retrying transient errors according
+// to the retry policy of the origin if retry is set. The outcome is recorded in the breaker once the retries are over,
+// along with the latency of the last attempt if timed is set. Timed attempts must return as soon as the origin starts
+// answering, so that the latency is the time to first byte and not the time taken to transfer the object.
+// It returns how many retries were made along with the error of the last attempt.
+func (s *MultiS3Store) call(index int, retry, timed bool, attempt func(instance ObjectStore, location string) error) (int, error) {
+	policy := RetryPolicy{}
+	if retry {
+		policy = s.policies[index]
+	}
+	var latency time.Duration
+	do := func() error {
+		start := time.Now()
+		err := attempt(s.instances[index], s.locations[index])
+		if timed {
+			latency = time.Since(start)
+		}
+		return err
+	}
+	if s.breakers == nil {
+		return policy.Do(do)
+	}
+	breaker := s.breakers[index]
+	ticket, allowed := breaker.Allow()
+	if !allowed {
+		return 0, errors.Err(ErrOriginUnavailable)
+	}
+	retries, err := policy.Do(do)
+	breaker.Record(ticket, err != nil && isTransientError(err), latency)
+	return retries, err
+}
+
+// traceName names the store in traces, along with the number of retries if there were any
+func (s *MultiS3Store) traceName(retries int) string {
+	if retries == 0 {
+		return s.Name()
 	}
-	return buf.Bytes(), shared.NewBlobTrace(time.Since(start), s.Name()), errors.Err(err)
+	return fmt.Sprintf("%s (retries: %d)", s.Name(), retries)
 }
 
-// Put stores the object on S3 or errors if S3 connection errors.
+// Put stores the object on the origin or errors if the origin connection errors.
 func (s *MultiS3Store) Put(hash string, object []byte, extra interface{}) error {
-	ex := s.getExtras(extra)
-	if ex == nil {
-		return errors.Err("%s requires an origin index to be specified in the extra params. use the MultiS3Extras struct.", nameMultiS3)
-	}
-	log.Debugf("Uploading %s to S3", hash[:8])
-	defer func(t time.Time) {
-		log.Debugf("Uploading %s took %s", hash[:8], time.Since(t).String())
-	}(time.Now())
-
-	_, err := s3manager.NewUploader(&s.instances[ex.S3Index].session).Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.instances[ex.S3Index].config.Bucket),
-		Key:    aws.String(hash),
-		Body:   bytes.NewBuffer(object),
-		ACL:    aws.String("public-read"),
+	index, err := s.getIndex(extra)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(index, true, false, func(instance ObjectStore, location string) error {
+		return PutStream(instance, hash, NewObjectStream(object), extra)
 	})
 	return err
 }
 
-func (s *MultiS3Store) Delete(hash string, extra interface{}) error {
-	ex := s.getExtras(extra)
-	if ex == nil {
-		return errors.Err("%s requires an origin index to be specified in the extra params. use the MultiS3Extras struct.", nameMultiS3)
+// PutStream uploads the object stream to the origin. Failed uploads aren't retried as the stream can't be read again.
+func (s *MultiS3Store) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	index, err := s.getIndex(extra)
+	if err != nil {
+		return err
 	}
-	log.Debugf("Deleting %s from S3", hash[:8])
-
-	_, err := s3.New(&s.instances[ex.S3Index].session).DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(s.instances[ex.S3Index].config.Bucket),
-		Key:    aws.String(hash),
+	_, err = s.call(index, false, false, func(instance ObjectStore, location string) error {
+		return PutStream(instance, hash, object, extra)
 	})
+	return err
+}
 
+func (s *MultiS3Store) Delete(hash string, extra interface{}) error {
+	index, err := s.getIndex(extra)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(index, true, true, func(instance ObjectStore, location string) error {
+		return instance.Delete(hash, extra)
+	})
 	return err
 }
 
 // Shutdown shuts down the store gracefully
 func (s *MultiS3Store) Shutdown() {
+	for _, instance := range s.instances {
+		instance.Shutdown()
+	}
 }
 
 func (s *MultiS3Store) getExtras(extra interface{}) *MultiS3Extras {
@@ -153,3 +344,15 @@ func (s *MultiS3Store) getExtras(extra interface{}) *MultiS3Extras {
 	}
 	return &ms
 }
+
+// getIndex returns the index of the origin selected by the extra params
+func (s *MultiS3Store) getIndex(extra interface{}) (int, error) {
+	ex := s.getExtras(extra)
+	if ex == nil {
+		return 0, errors.Err("%s requires an origin index to be specified in the extra params. use the MultiS3Extras struct.", nameMultiS3)
+	}
+	if ex.S3Index < 0 || ex.S3Index >= len(s.instances) {
+		return 0, errors.Err("%s has no origin at index %d", nameMultiS3, ex.S3Index)
+	}
+	return ex.S3Index, nil
+}
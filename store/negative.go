@@ -0,0 +1,154 @@
+package store
+
+import (
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+)
+
+// NegativeCacheStore remembers which objects the origin doesn't have, so that requests for missing objects don't
+// reach the origin over and over. Misses are remembered per origin for a limited time. Only objects the origin
+// reported as not found are remembered, errors such as timeouts aren't.
+type NegativeCacheStore struct {
+	origin ObjectStore
+	misses gcache.Cache
+}
+
+// negativeKey identifies a missing object
+type negativeKey struct {
+	origin int
+	name   string
+}
+
+// NewNegativeCacheStore returns an initialized negative cache store pointer remembering up to size misses for ttl
+func NewNegativeCacheStore(origin ObjectStore, size int, ttl time.Duration) *NegativeCacheStore {
+	return &NegativeCacheStore{
+		origin: origin,
+		misses: gcache.New(size).Expiration(ttl).ARC().Build(),
+	}
+}
+
+const nameNegativeCache = "negative-cache"
+
+// Name is the cache type name
+func (n *NegativeCacheStore) Name() string { return nameNegativeCache }
+
+// key returns the key of the object for the origin selected by the extra params
+func (n *NegativeCacheStore) key(hash string, extra interface{}) negativeKey {
+	key := negativeKey{name: hash}
+	if ex, ok := extra.(MultiS3Extras); ok {
+		key.origin = ex.S3Index
+	}
+	return key
+}
+
+// missing returns true if the origin recently reported the object as not found
+func (n *NegativeCacheStore) missing(hash string, extra interface{}) bool {
+	return n.misses.Has(n.key(hash, extra))
+}
+
+// record remembers the object as missing if err means the origin doesn't have it
+func (n *NegativeCacheStore) record(hash string, extra interface{}, err error) {
+	if errors.Is(err, ErrObjectNotFound) {
+		_ = n.misses.Set(n.key(hash, extra), true)
+	}
+}
+
+// Has returns whether the origin has the object
+func (n *NegativeCacheStore) Has(hash string, extra interface{}) (bool, error) {
+	if n.missing(hash, extra) {
+		return false, nil
+	}
+	has, err := n.origin.Has(hash, extra)
+	if err == nil && !has {
+		n.record(hash, extra, ErrObjectNotFound)
+	}
+	return has, err
+}
+
+// Get gets the object from the origin unless it's known to be missing
+func (n *NegativeCacheStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	start := time.Now()
+	if n.missing(hash, extra) {
+		return nil, shared.NewBlobTrace(time.Since(start), n.Name()), errors.Err(ErrObjectNotFound)
+	}
+	object, trace, err := n.origin.Get(hash, extra)
+	n.record(hash, extra, err)
+	return object, trace.Stack(time.Since(start), n.Name()), err
+}
+
+// GetStream streams the object from the origin unless it's known to be missing
+func (n *NegativeCacheStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	if n.missing(hash, extra) {
+		return nil, shared.NewBlobTrace(time.Since(start), n.Name()), errors.Err(ErrObjectNotFound)
+	}
+	stream, trace, err := GetStream(n.origin, hash, extra)
+	n.record(hash, extra, err)
+	return stream, trace.Stack(time.Since(start), n.Name()), err
+}
+
+// GetRange streams a range of the object from the origin unless it's known to be missing
+func (n *NegativeCacheStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	start := time.Now()
+	if n.missing(hash, extra) {
+		return nil, ContentRange{}, shared.NewBlobTrace(time.Since(start), n.Name()), errors.Err(ErrObjectNotFound)
+	}
+	stream, cr, trace, err := GetRange(n.origin, hash, r, extra)
+	n.record(hash, extra, err)
+	return stream, cr, trace.Stack(time.Since(start), n.Name()), err
+}
+
+// Revalidate asks the origin whether the copy of the object described by meta is still current, unless the object is
+// known to be missing
+func (n *NegativeCacheStore) Revalidate(hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error) {
+	start := time.Now()
+	if n.missing(hash, extra) {
+		return nil, 0, shared.NewBlobTrace(time.Since(start), n.Name()), errors.Err(ErrObjectNotFound)
+	}
+	current, size, trace, err := Revalidate(n.origin, hash, meta, extra)
+	n.record(hash, extra, err)
+	return current, size, trace.Stack(time.Since(start), n.Name()), err
+}
+
+// Put stores the object in the origin, which then no longer misses it
+func (n *NegativeCacheStore) Put(hash string, object []byte, extra interface{}) error {
+	n.misses.Remove(n.key(hash, extra))
+	return n.origin.Put(hash, object, extra)
+}
+
+// PutStream stores the object in the origin, which then no longer misses it
+func (n *NegativeCacheStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	n.misses.Remove(n.key(hash, extra))
+	return PutStream(n.origin, hash, object, extra)
+}
+
+// Delete deletes the object from the origin
+func (n *NegativeCacheStore) Delete(hash string, extra interface{}) error {
+	return n.origin.Delete(hash, extra)
+}
+
+// Forget forgets that the object is missing from any origin, returning how many misses were forgotten
+func (n *NegativeCacheStore) Forget(hash string) int {
+	forgotten := 0
+	for _, k := range n.misses.Keys(false) {
+		if k.(negativeKey).name == hash && n.misses.Remove(k) {
+			forgotten++
+		}
+	}
+	return forgotten
+}
+
+// Clear forgets all the misses, returning how many there were
+func (n *NegativeCacheStore) Clear() int {
+	cleared := n.misses.Len(false)
+	n.misses.Purge()
+	return cleared
+}
+
+// Shutdown shuts down the store gracefully
+func (n *NegativeCacheStore) Shutdown() {
+	n.origin.Shutdown()
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// ObjectMeta is what the origin tells about an object besides its content. It's kept along with cached objects so
+// that clients get the same headers whether the object comes from the origin or from the cache.
+type ObjectMeta struct {
+	ContentType  string    `json:"content_type,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	CacheControl string    `json:"cache_control,omitempty"`
+	// UserMetadata holds the user defined metadata (x-amz-meta-* headers), keyed by the name following the prefix
+	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+	// CachedAt is when the object was cached, zero for objects that aren't
+	CachedAt time.Time `json:"cached_at"`
+	// ValidatedAt is when the cached copy was last found to be the same as the origin's, zero if it never was
+	ValidatedAt time.Time `json:"validated_at"`
+	// ContentEncoding is set on the compressed copies of objects
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// checksumETag is set when the ETag wasn't given by the origin but derived from the checksum of the cached copy
+	checksumETag bool
+}
+
+// validated returns when the cached copy was known to be current for the last time
+func (m *ObjectMeta) validated() time.Time {
+	if m.ValidatedAt.After(m.CachedAt) {
+		return m.ValidatedAt
+	}
+	return m.CachedAt
+}
+
+// Modified returns when the object was last modified according to the origin, or when it was cached if the origin
+// didn't tell
+func (m *ObjectMeta) Modified() time.Time {
+	if m.LastModified.IsZero() {
+		return m.CachedAt
+	}
+	return m.LastModified
+}
+
+// userMetadataPrefix is the prefix of the headers carrying user defined metadata
+const userMetadataPrefix = "X-Amz-Meta-"
+
+// Headers returns the HTTP headers describing the object
+func (m *ObjectMeta) Headers() map[string]string {
+	headers := make(map[string]string, 4+len(m.UserMetadata))
+	if m.ContentType != "" {
+		headers["Content-Type"] = m.ContentType
+	}
+	if m.ETag != "" {
+		headers["ETag"] = m.ETag
+	}
+	if modified := m.Modified(); !modified.IsZero() {
+		headers["Last-Modified"] = modified.UTC().Format(http.TimeFormat)
+	}
+	if m.CacheControl != "" {
+		headers["Cache-Control"] = m.CacheControl
+	}
+	if m.ContentEncoding != "" {
+		headers["Content-Encoding"] = m.ContentEncoding
+	}
+	for k, v := range m.UserMetadata {
+		headers[http.CanonicalHeaderKey(userMetadataPrefix+k)] = v
+	}
+	return headers
+}
+
+// metaFromHeader returns the metadata found in the headers of an HTTP response, nil if there's none
+func metaFromHeader(header http.Header) *ObjectMeta {
+	meta := &ObjectMeta{
+		ContentType:  header.Get("Content-Type"),
+		ETag:         header.Get("ETag"),
+		CacheControl: header.Get("Cache-Control"),
+	}
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		meta.LastModified = lastModified
+	}
+	for k, v := range header {
+		if name, found := strings.CutPrefix(k, userMetadataPrefix); found && len(v) > 0 {
+			if meta.UserMetadata == nil {
+				meta.UserMetadata = make(map[string]string)
+			}
+			meta.UserMetadata[strings.ToLower(name)] = v[0]
+		}
+	}
+	return meta.orNil()
+}
+
+// metaFromS3 returns the metadata S3 returned along with the object, nil if there's none
+func metaFromS3(out *s3.GetObjectOutput) *ObjectMeta {
+	meta := &ObjectMeta{
+		ContentType:  aws.StringValue(out.ContentType),
+		ETag:         aws.StringValue(out.ETag),
+		LastModified: aws.TimeValue(out.LastModified),
+		CacheControl: aws.StringValue(out.CacheControl),
+	}
+	for k, v := range out.Metadata {
+		if meta.UserMetadata == nil {
+			meta.UserMetadata = make(map[string]string, len(out.Metadata))
+		}
+		meta.UserMetadata[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	return meta.orNil()
+}
+
+// orNil returns nil if the metadata is empty
+func (m *ObjectMeta) orNil() *ObjectMeta {
+	if m.ContentType == "" && m.ETag == "" && m.LastModified.IsZero() && m.CacheControl == "" && len(m.UserMetadata) == 0 && m.CachedAt.IsZero() && m.ValidatedAt.IsZero() {
+		return nil
+	}
+	return m
+}
+
+// marshalMeta returns the metadata as stored in the db, nil if there's none
+func marshalMeta(meta *ObjectMeta) interface{} {
+	if meta == nil {
+		return nil
+	}
+	serialized, err := json.Marshal(meta)
+	if err != nil {
+		log.Errorf("error serializing object metadata: %s", err.Error())
+		return nil
+	}
+	return string(serialized)
+}
+
+// unmarshalMeta returns the metadata stored in the db, nil if there's none
+func unmarshalMeta(serialized sql.NullString) *ObjectMeta {
+	if !serialized.Valid || serialized.String == "" {
+		return nil
+	}
+	var meta ObjectMeta
+	err := json.Unmarshal([]byte(serialized.String), &meta)
+	if err != nil {
+		log.Errorf("invalid object metadata in the db: %s", err.Error())
+		return nil
+	}
+	return &meta
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"net/url"
+	"time"
+)
+
+const nameParent = "parent"
+
+// NewParentStore returns a read only store fetching objects from a parent gody-cdn node acting as a shield in front of
+// the buckets, so that only the parents pull objects from them. origin is the name of the origin the parent is asked
+// to get the objects from. The parent's trace is included in the traces of the store.
+func NewParentStore(parentURL string, headers map[string]string, timeout time.Duration, origin string) *HTTPStore {
+	parent := NewHTTPStore(parentURL, headers, timeout, nil)
+	parent.name = nameParent
+	parent.mergeVia = true
+	parent.query = func(hash string, extra interface{}) url.Values {
+		return nodeQuery(hash, origin)
+	}
+	return parent
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+	log "github.com/sirupsen/logrus"
+)
+
+// PeerHeader marks requests sent by a node to its peers. Nodes don't forward such requests to their own peers,
+// which prevents requests from bouncing between nodes.
+const PeerHeader = "X-Gody-Peer"
+
+// PeerStore looks for objects on sibling gody-cdn nodes before going to the origin, so that an object missing from
+// every node is only fetched from the origin once per region instead of once per node.
+// Only reads go to the peers, everything else goes straight to the origin.
+type PeerStore struct {
+	origin ObjectStore
+	peers  []*HTTPStore
+}
+
+// NewPeerStore returns an initialized PeerStore pointer. peerURLs are the base URLs of the sibling nodes, timeout
+// limits how long a peer can take to answer and originNames holds the name under which each origin can be selected
+// in requests, in the order of the origins.
+func NewPeerStore(peerURLs []string, timeout time.Duration, originNames []string, origin ObjectStore) *PeerStore {
+	query := func(hash string, extra interface{}) url.Values {
+		origin := ""
+		if ex, ok := extra.(MultiS3Extras); ok && ex.S3Index > 0 && ex.S3Index < len(originNames) {
+			origin = originNames[ex.S3Index]
+		}
+		return nodeQuery(hash, origin)
+	}
+	ps := &PeerStore{origin: origin}
+	for _, peerURL := range peerURLs {
+		peer := NewHTTPStore(peerURL, map[string]string{PeerHeader: "1"}, timeout, nil)
+		peer.query = query
+		ps.peers = append(ps.peers, peer)
+	}
+	return ps
+}
+
+const namePeer = "peer"
+
+// Name is the cache type name
+func (p *PeerStore) Name() string { return namePeer }
+
+// Has returns whether the origin has the object
+func (p *PeerStore) Has(hash string, extra interface{}) (bool, error) {
+	return p.origin.Has(hash, extra)
+}
+
+// Get gets the object from a peer that has it cached or from the origin
+func (p *PeerStore) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace, error) {
+	start := time.Now()
+	if peer := p.findPeer(hash, extra); peer != nil {
+		object, trace, err := peer.Get(hash, extra)
+		if err == nil {
+			return object, trace.Stack(time.Since(start), p.Name()), nil
+		}
+		log.Warnf("error getting %s from peer %s: %s", truncate(hash), peer.baseURL, err.Error())
+	}
+	object, trace, err := p.origin.Get(hash, extra)
+	return object, trace.Stack(time.Since(start), p.Name()), err
+}
+
+// GetStream streams the object from a peer that has it cached or from the origin
+func (p *PeerStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	if peer := p.findPeer(hash, extra); peer != nil {
+		stream, trace, err := peer.GetStream(hash, extra)
+		if err == nil {
+			return stream, trace.Stack(time.Since(start), p.Name()), nil
+		}
+		log.Warnf("error streaming %s from peer %s: %s", truncate(hash), peer.baseURL, err.Error())
+	}
+	stream, trace, err := GetStream(p.origin, hash, extra)
+	return stream, trace.Stack(time.Since(start), p.Name()), err
+}
+
+// GetRange streams a range of the object from a peer that has it cached or from the origin
+func (p *PeerStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	start := time.Now()
+	if peer := p.findPeer(hash, extra); peer != nil {
+		stream, cr, trace, err := peer.GetRange(hash, r, extra)
+		if err == nil || errors.Is(err, ErrRangeNotSatisfiable) {
+			return stream, cr, trace.Stack(time.Since(start), p.Name()), err
+		}
+		log.Warnf("error streaming %s of %s from peer %s: %s", r.String(), truncate(hash), peer.baseURL, err.Error())
+	}
+	stream, cr, trace, err := GetRange(p.origin, hash, r, extra)
+	return stream, cr, trace.Stack(time.Since(start), p.Name()), err
+}
+
+// Revalidate asks the origin whether the copy of the object described by meta is still current. Peers are left out:
+// their copy may be as old as this node's.
+func (p *PeerStore) Revalidate(hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error) {
+	start := time.Now()
+	current, size, trace, err := Revalidate(p.origin, hash, meta, extra)
+	return current, size, trace.Stack(time.Since(start), p.Name()), err
+}
+
+// nodeQuery returns the query string parameters of a request for an object to another gody-cdn node, origin being the
+// name of the origin to get it from or empty for the default one
+func nodeQuery(hash string, origin string) url.Values {
+	values := url.Values{}
+	// the object parameter is what the hasObject route looks at
+	values.Set("object", hash)
+	if origin != "" {
+		values.Set("origin", origin)
+	}
+	return values
+}
+
+// findPeer asks all the peers at once whether they have the object cached and returns the first one that does,
+// or nil if none does. Requests coming from a peer are never forwarded to the peers.
+func (p *PeerStore) findPeer(hash string, extra interface{}) *HTTPStore {
+	if ex, ok := extra.(MultiS3Extras); len(p.peers) == 0 || (ok && ex.FromPeer) {
+		return nil
+	}
+	found := make(chan *HTTPStore, len(p.peers))
+	for _, peer := range p.peers {
+		go func(peer *HTTPStore) {
+			has, err := peer.Has(hash, extra)
+			if err != nil {
+				log.Debugf("error asking peer %s for %s: %s", peer.baseURL, truncate(hash), err.Error())
+			}
+			if has {
+				found <- peer
+				return
+			}
+			found <- nil
+		}(peer)
+	}
+	for range p.peers {
+		if peer := <-found; peer != nil {
+			return peer
+		}
+	}
+	return nil
+}
+
+// Put stores the object in the origin
+func (p *PeerStore) Put(hash string, object []byte, extra interface{}) error {
+	return p.origin.Put(hash, object, extra)
+}
+
+// PutStream stores the object in the origin
+func (p *PeerStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	return PutStream(p.origin, hash, object, extra)
+}
+
+// Delete deletes the object from the origin
+func (p *PeerStore) Delete(hash string, extra interface{}) error {
+	return p.origin.Delete(hash, extra)
+}
+
+// Shutdown shuts down the store gracefully
+func (p *PeerStore) Shutdown() {
+	for _, peer := range p.peers {
+		peer.Shutdown()
+	}
+	p.origin.Shutdown()
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"strconv"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// purgeBatch is how many records are looked up at once when purging by name
+const purgeBatch = 1000
+
+// metadataHolder is implemented by stores keeping track of their objects in a MetadataStore
+type metadataHolder interface {
+	Metadata() MetadataStore
+}
+
+// Purge drops the cached copies of the object, leaving the origin alone, so that it's fetched again on the next
+// request. Objects are cached under their name only: when origin isn't negative, the copy is only dropped if it was
+// fetched from the origin with that index (or from an unknown one). It returns how many objects were removed from the
+// cache, counting chunks and sizes of chunked objects separately.
+func (c *CachingStore) Purge(originalName string, origin int) (int, error) {
+	purged, err := c.purgeMatching(originalName, true, origin)
+	if err != nil || origin >= 0 {
+		return purged, err
+	}
+	// objects cached before their names were recorded can still be found by their hash
+	has, err := c.HasCached(originalName, nil)
+	if err != nil || !has {
+		return purged, err
+	}
+	err = c.dropCached(hashName(originalName))
+	if err != nil {
+		return purged, err
+	}
+	return purged + 1, nil
+}
+
+// PurgePrefix drops the cached copies of all the objects whose name starts with prefix, like Purge does. Only the
+// objects cached since their names are recorded can be found.
+func (c *CachingStore) PurgePrefix(prefix string, origin int) (int, error) {
+	return c.purgeMatching(prefix, false, origin)
+}
+
+// purgeMatching drops the cached objects whose name starts with prefix, or is prefix when exact is set
+func (c *CachingStore) purgeMatching(prefix string, exact bool, origin int) (int, error) {
+	holder, ok := unwrap(c.cache).(metadataHolder)
+	if !ok {
+		return 0, errors.Err("%s can't look objects up by name", c.cache.Name())
+	}
+	purged := 0
+	var last ObjectRecord
+	// forgotten is the name of the last object whose copies outside of the cache were dropped, its chunks come along
+	forgotten := ""
+	for {
+		records, err := holder.Metadata().ListByName(prefix, last.Name, last.Hash, purgeBatch)
+		if err != nil {
+			return purged, err
+		}
+		if len(records) == 0 {
+			return purged, nil
+		}
+		for _, record := range records {
+			if exact && record.Name != prefix {
+				// exact matches sort before any longer name
+				return purged, nil
+			}
+			if origin >= 0 && record.Origin != nil && *record.Origin != origin {
+				continue
+			}
+			if record.Name != forgotten {
+				c.forget(hashName(record.Name))
+				forgotten = record.Name
+			}
+			err = c.cache.Delete(record.Hash, nil)
+			if err != nil {
+				return purged, err
+			}
+			log.Debugf("purged %s (%s) from the cache", record.Hash, record.Name)
+			purged++
+		}
+		last = records[len(records)-1]
+	}
+}
+
+// dropCached removes every cached copy of the object: the whole object, its compressed copies and, if it was cached
+// in chunks, its chunks and its size
+func (c *CachingStore) dropCached(hashedName string) error {
+	c.forget(hashedName)
+	for encoding := range encoders {
+		err := c.cache.Delete(encodedKey(hashedName, encoding), nil)
+		if err != nil {
+			return err
+		}
+	}
+	if c.chunking != nil {
+		var size int64
+		if cached, _, err := c.cache.Get(sizeKey(hashedName), nil); err == nil {
+			size, _ = strconv.ParseInt(string(cached), 10, 64)
+		}
+		for i := int64(0); i*c.chunking.chunkSize < size; i++ {
+			err := c.cache.Delete(chunkKey(hashedName, i), nil)
+			if err != nil {
+				return err
+			}
+		}
+		err := c.cache.Delete(sizeKey(hashedName), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return c.cache.Delete(hashedName, nil)
+}
+
+// forget drops what's remembered about the object outside of the cache: its copy in the memory tier and its size
+func (c *CachingStore) forget(hashedName string) {
+	if c.hot != nil {
+		_ = c.hot.Delete(hashedName, nil)
+	}
+	if c.chunking != nil {
+		c.chunking.sizes.Remove(hashedName)
+	}
+}
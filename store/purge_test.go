@@ -0,0 +1,87 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newDBBackedStore returns a store keeping objects in a temporary directory and their records in a temporary SQLite db
+func newDBBackedStore(t *testing.T) *DBBackedStore {
+	disk, err := NewDiskStore(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDBBackedStore(disk, newSQLiteMetadata(t))
+}
+
+func TestPurgePrefix(t *testing.T) {
+	// objects is the origin each object is fetched from
+	objects := map[string]int{
+		"videos/a/1.ts":    0,
+		"videos/a/2.ts":    1,
+		"videos/a/é.ts":    0,
+		"videos/a/big":     0,
+		"videos/ab.ts":     0,
+		"videos/b/1.ts":    1,
+		"videos\U0010FFFF": 0,
+		"videot":           0,
+	}
+	tests := []struct {
+		prefix string
+		origin int
+		purged []string
+	}{
+		{"videos/a/", -1, []string{"videos/a/1.ts", "videos/a/2.ts", "videos/a/big", "videos/a/é.ts"}},
+		{"videos/a/", 0, []string{"videos/a/1.ts", "videos/a/big", "videos/a/é.ts"}},
+		{"videos/a", 1, []string{"videos/a/2.ts"}},
+		{"videos/", -1, []string{"videos/a/1.ts", "videos/a/2.ts", "videos/a/big", "videos/a/é.ts", "videos/ab.ts", "videos/b/1.ts"}},
+		{"videos", 1, []string{"videos/a/2.ts", "videos/b/1.ts"}},
+		{"videos/c/", -1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s from %d", tt.prefix, tt.origin), func(t *testing.T) {
+			origin := NewMemoryStore(1<<20, 1<<20)
+			for name := range objects {
+				object := []byte(name)
+				if strings.HasSuffix(name, "big") {
+					// cached in chunks, under several records sharing its name
+					object = []byte(chunkedObject)
+				}
+				if err := origin.Put(name, object, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := NewCachingStore("test", origin, newDBBackedStore(t)).
+				WithChunking(16, 4).
+				WithHotTier(NewMemoryStore(1<<20, 1<<20))
+			// the second read promotes the objects cached whole to the memory tier
+			for i := 0; i < 2; i++ {
+				for name, index := range objects {
+					if _, _, err := c.Get(name, MultiS3Extras{S3Index: index}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if _, err := c.PurgePrefix(tt.prefix, tt.origin); err != nil {
+				t.Fatal(err)
+			}
+			var purged []string
+			for name := range objects {
+				has, err := c.HasCached(name, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !has {
+					purged = append(purged, name)
+				}
+			}
+			sort.Strings(purged)
+			if strings.Join(purged, ",") != strings.Join(tt.purged, ",") {
+				t.Errorf("expected %v to be purged, got %v", tt.purged, purged)
+			}
+		})
+	}
+}
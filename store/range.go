@@ -0,0 +1,157 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+)
+
+// RangeObjectStore is an ObjectStore that can serve a part of an object without reading the whole of it.
+type RangeObjectStore interface {
+	ObjectStore
+	// GetRange returns a stream of the requested range of the object along with the range that's actually served.
+	// Must return ErrObjectNotFound if object is not in store and ErrRangeNotSatisfiable (with the object size
+	// in the returned ContentRange when known) if the range lies outside the object.
+	GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error)
+}
+
+// ErrRangeNotSatisfiable is returned when a requested range doesn't overlap the object
+var ErrRangeNotSatisfiable = errors.Base("range not satisfiable")
+
+// ByteRange is a single range of bytes as requested through an HTTP Range header.
+// Start is -1 for suffix ranges, in which case End is the amount of trailing bytes requested.
+// End is -1 for ranges that extend to the end of the object.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// ContentRange is the inclusive range of bytes served out of an object of Size bytes
+type ContentRange struct {
+	Start int64
+	End   int64
+	Size  int64
+}
+
+// Length is the amount of bytes in the range
+func (c ContentRange) Length() int64 {
+	return c.End - c.Start + 1
+}
+
+// Resolve returns the part of an object of the given size that the range covers
+func (r ByteRange) Resolve(size int64) (ContentRange, error) {
+	cr := ContentRange{Size: size}
+	switch {
+	case r.Start < 0:
+		if r.End <= 0 || size == 0 {
+			return cr, errors.Err(ErrRangeNotSatisfiable)
+		}
+		cr.Start = size - r.End
+		if cr.Start < 0 {
+			cr.Start = 0
+		}
+		cr.End = size - 1
+	default:
+		if r.Start >= size {
+			return cr, errors.Err(ErrRangeNotSatisfiable)
+		}
+		cr.Start = r.Start
+		cr.End = r.End
+		if cr.End < 0 || cr.End >= size {
+			cr.End = size - 1
+		}
+	}
+	return cr, nil
+}
+
+// String returns the range in the format of an HTTP Range header
+func (r ByteRange) String() string {
+	switch {
+	case r.Start < 0:
+		return fmt.Sprintf("bytes=-%d", r.End)
+	case r.End < 0:
+		return fmt.Sprintf("bytes=%d-", r.Start)
+	default:
+		return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+	}
+}
+
+// parseContentRange parses the value of a Content-Range header such as "bytes 0-99/1234" or "bytes */1234"
+func parseContentRange(header string) (ContentRange, error) {
+	var cr ContentRange
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return cr, errors.Err("invalid content range %q", header)
+	}
+	span, size, found := strings.Cut(spec, "/")
+	if !found {
+		return cr, errors.Err("invalid content range %q", header)
+	}
+	var err error
+	if span == "*" {
+		// unsatisfied range, only the size is known
+		cr.Size, err = strconv.ParseInt(size, 10, 64)
+		return cr, errors.Err(err)
+	}
+	start, end, found := strings.Cut(span, "-")
+	if !found {
+		return cr, errors.Err("invalid content range %q", header)
+	}
+	if cr.Start, err = strconv.ParseInt(start, 10, 64); err != nil {
+		return cr, errors.Err(err)
+	}
+	if cr.End, err = strconv.ParseInt(end, 10, 64); err != nil {
+		return cr, errors.Err(err)
+	}
+	if cr.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return cr, errors.Err(err)
+	}
+	return cr, nil
+}
+
+// GetRange gets a range of the object from the store. Stores that can't serve ranges are streamed
+// from the start of the object, seeking when the stream allows it.
+func GetRange(s ObjectStore, hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	if rs, ok := s.(RangeObjectStore); ok {
+		return rs.GetRange(hash, r, extra)
+	}
+	stream, trace, err := GetStream(s, hash, extra)
+	if err != nil {
+		return nil, ContentRange{}, trace, err
+	}
+	cr, err := sliceStream(stream, r)
+	if err != nil {
+		return nil, cr, trace, err
+	}
+	return stream, cr, trace, nil
+}
+
+// sliceStream narrows down a full object stream to the requested range. The stream is closed on errors.
+func sliceStream(stream *ObjectStream, r ByteRange) (ContentRange, error) {
+	cr, err := r.Resolve(stream.Size)
+	if err != nil {
+		_ = stream.Close()
+		return cr, err
+	}
+	if seeker, ok := stream.ReadCloser.(io.Seeker); ok {
+		_, err = seeker.Seek(cr.Start, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, stream, cr.Start)
+	}
+	if err != nil {
+		_ = stream.Close()
+		return cr, errors.Err(err)
+	}
+	stream.ReadCloser = limitedReadCloser{Reader: io.LimitReader(stream.ReadCloser, cr.Length()), Closer: stream.ReadCloser}
+	stream.Size = cr.Length()
+	return cr, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
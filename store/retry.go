@@ -0,0 +1,82 @@
+package store
+
+import (
+	goerrors "errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// RetryPolicy decides how many times, and how far apart, a request that failed with a transient error is retried.
+// The zero value doesn't retry.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles with every retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Deadline is how long after the first attempt a retry can still be started, 0 means no deadline
+	Deadline time.Duration
+}
+
+// Do calls attempt until it succeeds, fails with an error that isn't transient or the policy gives up.
+// It returns how many times attempt was retried along with the error of the last attempt.
+func (p RetryPolicy) Do(attempt func() error) (int, error) {
+	start := time.Now()
+	retries := 0
+	for {
+		err := attempt()
+		if err == nil || !isTransientError(err) || retries+1 >= p.MaxAttempts {
+			return retries, err
+		}
+		delay := p.backoff(retries)
+		if p.Deadline > 0 && time.Since(start)+delay > p.Deadline {
+			return retries, err
+		}
+		time.Sleep(delay)
+		retries++
+	}
+}
+
+// backoff returns how long to wait before the given retry: a random delay up to the exponential backoff ("full jitter")
+// so that clients failing together don't retry together
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.BaseDelay << uint(retry)
+	if backoff <= 0 || (p.MaxDelay > 0 && backoff > p.MaxDelay) {
+		// the shift can overflow after many retries
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// isTransientError returns true if the error is likely to go away on its own, so the request can be retried:
+// server side errors, throttling, timeouts and connection errors. Missing objects or buckets and denied requests aren't.
+func isTransientError(err error) bool {
+	err = errors.Unwrap(err)
+	if statusErr, ok := err.(*HTTPStatusError); ok {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	if reqFail, ok := err.(awserr.RequestFailure); ok && reqFail.StatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case request.ErrCodeRequestError, request.ErrCodeResponseTimeout, request.ErrCodeRead, "RequestTimeout", "SlowDown", "ServiceUnavailable", "InternalError":
+			return true
+		}
+		err = aerr.OrigErr()
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return goerrors.Is(err, syscall.ECONNRESET) || goerrors.Is(err, syscall.ECONNREFUSED)
+}
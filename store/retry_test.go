@@ -0,0 +1,107 @@
+package store
+
+import (
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		retry  int
+		// max is the longest the delay can be
+		max time.Duration
+	}{
+		{"no delay", RetryPolicy{}, 0, 0},
+		{"first retry", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 0, 100 * time.Millisecond},
+		{"doubled", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 1, 200 * time.Millisecond},
+		{"doubled twice", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 2, 400 * time.Millisecond},
+		{"capped", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 5, time.Second},
+		{"no cap", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 5, 3200 * time.Millisecond},
+		{"overflow capped", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 100, time.Second},
+		{"overflow without cap", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var longest time.Duration
+			for i := 0; i < 1000; i++ {
+				d := tt.policy.backoff(tt.retry)
+				if d < 0 || d > tt.max {
+					t.Fatalf("expected a delay between 0 and %s, got %s", tt.max, d)
+				}
+				longest = max(longest, d)
+			}
+			// the delays are spread over the whole range
+			if longest < tt.max/2 {
+				t.Errorf("expected delays up to %s, the longest was %s", tt.max, longest)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := errors.Err(&HTTPStatusError{StatusCode: http.StatusServiceUnavailable})
+	permanent := errors.Err(ErrObjectNotFound)
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{"success", RetryPolicy{MaxAttempts: 3}, []error{nil}, 1, nil},
+		{"zero value doesn't retry", RetryPolicy{}, []error{transient, nil}, 1, transient},
+		{"retried until success", RetryPolicy{MaxAttempts: 3}, []error{transient, transient, nil}, 3, nil},
+		{"gives up", RetryPolicy{MaxAttempts: 3}, []error{transient, transient, transient, nil}, 3, transient},
+		{"permanent errors aren't retried", RetryPolicy{MaxAttempts: 3}, []error{permanent, nil}, 1, permanent},
+		{"retried until a permanent error", RetryPolicy{MaxAttempts: 3}, []error{transient, permanent, nil}, 2, permanent},
+		{"past the deadline", RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour, Deadline: time.Millisecond}, []error{transient, nil}, 1, transient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			retries, err := tt.policy.Do(func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if attempts != tt.attempts || retries != tt.attempts-1 || err != tt.err {
+				t.Errorf("expected %d attempts ending with %v, got %d attempts, %d retries ending with %v", tt.attempts, tt.err, attempts, retries, err)
+			}
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"server error", &HTTPStatusError{StatusCode: http.StatusBadGateway}, true},
+		{"throttled", &HTTPStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"missing object", &HTTPStatusError{StatusCode: http.StatusNotFound}, false},
+		{"denied", &HTTPStatusError{StatusCode: http.StatusForbidden}, false},
+		{"wrapped", errors.Err(&HTTPStatusError{StatusCode: http.StatusInternalServerError}), true},
+		{"s3 server error", awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, ""), true},
+		{"s3 slow down", awserr.NewRequestFailure(awserr.New("SlowDown", "", nil), http.StatusServiceUnavailable, ""), true},
+		{"s3 missing object", awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "", nil), http.StatusNotFound, ""), false},
+		{"s3 connection error", awserr.New("RequestError", "", syscall.ECONNREFUSED), true},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"connection refused", errors.Err(syscall.ECONNREFUSED), true},
+		{"not found", ErrObjectNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.transient {
+				t.Errorf("expected %t, got %t", tt.transient, got)
+			}
+		})
+	}
+}
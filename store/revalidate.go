@@ -0,0 +1,59 @@
+package store
+
+import (
+	"net/http"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/reflector.go/shared"
+)
+
+// RevalidatingObjectStore is an ObjectStore that can tell whether a copy of an object is still current with a
+// conditional request, without sending the object.
+type RevalidatingObjectStore interface {
+	ObjectStore
+	// Revalidate returns ErrNotModified if the object is still the version described by the validators (ETag and
+	// modification time) of meta, otherwise the metadata and size of the current version. Must return
+	// ErrObjectNotFound if object is not in store.
+	Revalidate(hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error)
+}
+
+// ErrNotModified is returned when revalidating a copy of an object that's still current
+var ErrNotModified = errors.Base("not modified")
+
+// Revalidate asks the store whether the copy of the object described by meta is still current. Stores that can't
+// revalidate are asked for the first byte of the object, so that its metadata and size can be compared with the copy's.
+func Revalidate(s ObjectStore, hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error) {
+	if rs, ok := s.(RevalidatingObjectStore); ok {
+		return rs.Revalidate(hash, meta, extra)
+	}
+	stream, cr, trace, err := GetRange(s, hash, ByteRange{Start: 0, End: 0}, extra)
+	current, size, err := rangeMeta(stream, cr, err)
+	return current, size, trace, err
+}
+
+// rangeMeta returns the metadata and the size of the object a range was requested from, closing the stream of the
+// range. Empty objects have no range to serve but their size is known all the same.
+func rangeMeta(stream *ObjectStream, cr ContentRange, err error) (*ObjectMeta, int64, error) {
+	if err != nil && !errors.Is(err, ErrRangeNotSatisfiable) {
+		return nil, 0, err
+	}
+	if stream == nil {
+		return nil, cr.Size, nil
+	}
+	_ = stream.Close()
+	return stream.Meta, cr.Size, nil
+}
+
+// conditionalHeaders returns the headers making a request conditional on the object having changed since the copy
+// described by meta: the ETag if the origin gave one, the modification time otherwise
+func conditionalHeaders(meta *ObjectMeta) map[string]string {
+	headers := make(map[string]string, 1)
+	switch {
+	case meta == nil:
+	case meta.ETag != "" && !meta.checksumETag:
+		headers["If-None-Match"] = meta.ETag
+	case !meta.LastModified.IsZero():
+		headers["If-Modified-Since"] = meta.LastModified.UTC().Format(http.TimeFormat)
+	}
+	return headers
+}
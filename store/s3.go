@@ -1,7 +1,7 @@
 package store
 
 import (
-	"bytes"
+	"io"
 	"net/http"
 	"time"
 
@@ -36,6 +36,9 @@ const nameS3 = "s3"
 // Name is the cache type name
 func (s *S3Store) Name() string { return nameS3 }
 
+// traceName identifies the bucket in traces
+func (s *S3Store) traceName() string { return s.Name() + ":" + s.config.Bucket }
+
 // Has returns T/F or Error ( from S3 ) if the store contains the object.
 func (s *S3Store) Has(hash string, extra interface{}) (bool, error) {
 	err := s.initOnce()
@@ -63,12 +66,9 @@ func (s *S3Store) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace,
 	//Todo-Need to handle error for object doesn't exist for consistency.
 	err := s.initOnce()
 	if err != nil {
-		return nil, shared.NewBlobTrace(time.Since(start), s.Name()), err
-	}
-	truncatedHash := hash
-	if len(hash) > 8 {
-		truncatedHash = hash[:8]
+		return nil, shared.NewBlobTrace(time.Since(start), s.traceName()), err
 	}
+	truncatedHash := truncate(hash)
 	log.Debugf("Getting %s from S3", truncatedHash)
 	defer func(t time.Time) {
 		log.Debugf("Getting %s from S3 took %s", truncatedHash, time.Since(t).String())
@@ -80,16 +80,131 @@ func (s *S3Store) Get(hash string, extra interface{}) ([]byte, shared.BlobTrace,
 		Key:    aws.String(hash),
 	})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-				return nil, shared.NewBlobTrace(time.Since(start), s.Name()), errors.Err("bucket %s does not exist", s.config.Bucket)
-			case s3.ErrCodeNoSuchKey:
-				return nil, shared.NewBlobTrace(time.Since(start), s.Name()), errors.Err(ErrObjectNotFound)
+		return nil, shared.NewBlobTrace(time.Since(start), s.traceName()), s.translateError(err)
+	}
+	return buf.Bytes(), shared.NewBlobTrace(time.Since(start), s.traceName()), nil
+}
+
+// GetStream returns the body of the object as it's being downloaded from S3.
+func (s *S3Store) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	err := s.initOnce()
+	if err != nil {
+		return nil, shared.NewBlobTrace(time.Since(start), s.traceName()), err
+	}
+	log.Debugf("Streaming %s from S3", truncate(hash))
+
+	out, err := s3.New(s.session).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(hash),
+	})
+	if err != nil {
+		return nil, shared.NewBlobTrace(time.Since(start), s.traceName()), s.translateError(err)
+	}
+	return &ObjectStream{ReadCloser: out.Body, Size: aws.Int64Value(out.ContentLength), Meta: metaFromS3(out)}, shared.NewBlobTrace(time.Since(start), s.traceName()), nil
+}
+
+// GetRange returns a stream of the requested range of the object as it's being downloaded from S3.
+func (s *S3Store) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	start := time.Now()
+	err := s.initOnce()
+	if err != nil {
+		return nil, ContentRange{}, shared.NewBlobTrace(time.Since(start), s.traceName()), err
+	}
+	log.Debugf("Streaming %s of %s from S3", r.String(), truncate(hash))
+
+	out, err := s3.New(s.session).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(hash),
+		Range:  aws.String(r.String()),
+	})
+	if err != nil {
+		err = s.translateError(err)
+		if errors.Is(err, ErrRangeNotSatisfiable) {
+			// S3 doesn't tell how big the object is when the range is off, ask for it so the client can be told
+			head, headErr := s3.New(s.session).HeadObject(&s3.HeadObjectInput{
+				Bucket: aws.String(s.config.Bucket),
+				Key:    aws.String(hash),
+			})
+			if headErr == nil {
+				return nil, ContentRange{Size: aws.Int64Value(head.ContentLength)}, shared.NewBlobTrace(time.Since(start), s.traceName()), err
+			}
+		}
+		return nil, ContentRange{}, shared.NewBlobTrace(time.Since(start), s.traceName()), err
+	}
+	stream := &ObjectStream{ReadCloser: out.Body, Size: aws.Int64Value(out.ContentLength), Meta: metaFromS3(out)}
+	if out.ContentRange == nil {
+		// the whole object was returned, which happens when the range covers all of it
+		cr, err := sliceStream(stream, r)
+		if err != nil {
+			return nil, cr, shared.NewBlobTrace(time.Since(start), s.traceName()), err
+		}
+		return stream, cr, shared.NewBlobTrace(time.Since(start), s.traceName()), nil
+	}
+	cr, err := parseContentRange(aws.StringValue(out.ContentRange))
+	if err != nil {
+		_ = out.Body.Close()
+		return nil, cr, shared.NewBlobTrace(time.Since(start), s.traceName()), err
+	}
+	return stream, cr, shared.NewBlobTrace(time.Since(start), s.traceName()), nil
+}
+
+// Revalidate asks S3 for the headers of the object, conditionally on it having changed since the copy described by
+// meta, so that revalidating doesn't cost a GET
+func (s *S3Store) Revalidate(hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error) {
+	start := time.Now()
+	err := s.initOnce()
+	if err != nil {
+		return nil, 0, shared.NewBlobTrace(time.Since(start), s.traceName()), err
+	}
+	log.Debugf("Revalidating %s on S3", truncate(hash))
+
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(hash),
+	}
+	conditions := conditionalHeaders(meta)
+	if etag, ok := conditions["If-None-Match"]; ok {
+		input.IfNoneMatch = aws.String(etag)
+	} else if _, ok := conditions["If-Modified-Since"]; ok {
+		input.IfModifiedSince = aws.Time(meta.LastModified)
+	}
+	out, err := s3.New(s.session).HeadObject(input)
+	if err != nil {
+		// HEAD responses have no body, so S3 errors only come with their status code
+		if reqFail, ok := err.(s3.RequestFailure); ok {
+			switch reqFail.StatusCode() {
+			case http.StatusNotModified:
+				return nil, 0, shared.NewBlobTrace(time.Since(start), s.traceName()), errors.Err(ErrNotModified)
+			case http.StatusNotFound:
+				return nil, 0, shared.NewBlobTrace(time.Since(start), s.traceName()), errors.Err(ErrObjectNotFound)
 			}
 		}
+		return nil, 0, shared.NewBlobTrace(time.Since(start), s.traceName()), s.translateError(err)
 	}
-	return buf.Bytes(), shared.NewBlobTrace(time.Since(start), s.Name()), errors.Err(err)
+	current := metaFromS3(&s3.GetObjectOutput{
+		ContentType:  out.ContentType,
+		ETag:         out.ETag,
+		LastModified: out.LastModified,
+		CacheControl: out.CacheControl,
+		Metadata:     out.Metadata,
+	})
+	return current, aws.Int64Value(out.ContentLength), shared.NewBlobTrace(time.Since(start), s.traceName()), nil
+}
+
+// translateError maps S3 errors onto the errors the rest of the stores understand
+func (s *S3Store) translateError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchBucket:
+			return errors.Err("bucket %s does not exist", s.config.Bucket)
+		case s3.ErrCodeNoSuchKey:
+			return errors.Err(ErrObjectNotFound)
+		case "InvalidRange":
+			return errors.Err(ErrRangeNotSatisfiable)
+		}
+	}
+	return errors.Err(err)
 }
 
 // Put stores the object on S3 or errors if S3 connection errors.
@@ -99,18 +214,28 @@ func (s *S3Store) Put(hash string, object []byte, extra interface{}) error {
 		return err
 	}
 
-	log.Debugf("Uploading %s to S3", hash[:8])
+	return s.PutStream(hash, NewObjectStream(object), extra)
+}
+
+// PutStream uploads the object stream to S3 in parts, so the object never needs to be fully in memory.
+func (s *S3Store) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	err := s.initOnce()
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Uploading %s to S3", truncate(hash))
 	defer func(t time.Time) {
-		log.Debugf("Uploading %s took %s", hash[:8], time.Since(t).String())
+		log.Debugf("Uploading %s took %s", truncate(hash), time.Since(t).String())
 	}(time.Now())
 
 	_, err = s3manager.NewUploader(s.session).Upload(&s3manager.UploadInput{
 		Bucket: aws.String(s.config.Bucket),
 		Key:    aws.String(hash),
-		Body:   bytes.NewBuffer(object),
+		Body:   io.LimitReader(object, object.Size),
 		ACL:    aws.String("public-read"),
 	})
-	return err
+	return errors.Err(err)
 }
 
 func (s *S3Store) Delete(hash string, extra interface{}) error {
@@ -119,14 +244,14 @@ func (s *S3Store) Delete(hash string, extra interface{}) error {
 		return err
 	}
 
-	log.Debugf("Deleting %s from S3", hash[:8])
+	log.Debugf("Deleting %s from S3", truncate(hash))
 
 	_, err = s3.New(s.session).DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(s.config.Bucket),
 		Key:    aws.String(hash),
 	})
 
-	return err
+	return errors.Err(err)
 }
 
 func (s *S3Store) initOnce() error {
@@ -146,3 +271,11 @@ func (s *S3Store) initOnce() error {
 // Shutdown shuts down the store gracefully
 func (s *S3Store) Shutdown() {
 }
+
+// truncate shortens object names for logging purposes
+func truncate(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
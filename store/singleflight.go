@@ -89,6 +89,33 @@ func (s *singleFlightStore) putter(hash string, object []byte, extra interface{}
 	}
 }
 
+// GetStream is passed straight through to the origin: a stream can only be consumed once, so it can't be shared
+// between callers. Callers that need to protect the origin should fill a cache through PutStream instead.
+func (s *singleFlightStore) GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	start := time.Now()
+	stream, stack, err := GetStream(s.ObjectStore, hash, extra)
+	return stream, stack.Stack(time.Since(start), s.Name()), err
+}
+
+// GetRange is passed straight through to the origin for the same reason as GetStream
+func (s *singleFlightStore) GetRange(hash string, r ByteRange, extra interface{}) (*ObjectStream, ContentRange, shared.BlobTrace, error) {
+	start := time.Now()
+	stream, cr, stack, err := GetRange(s.ObjectStore, hash, r, extra)
+	return stream, cr, stack.Stack(time.Since(start), s.Name()), err
+}
+
+// Revalidate is passed straight through to the origin, revalidations are already made once at a time per object
+func (s *singleFlightStore) Revalidate(hash string, meta *ObjectMeta, extra interface{}) (*ObjectMeta, int64, shared.BlobTrace, error) {
+	start := time.Now()
+	current, size, stack, err := Revalidate(s.ObjectStore, hash, meta, extra)
+	return current, size, stack.Stack(time.Since(start), s.Name()), err
+}
+
+// PutStream is passed straight through to the origin for the same reason as GetStream
+func (s *singleFlightStore) PutStream(hash string, object *ObjectStream, extra interface{}) error {
+	return PutStream(s.ObjectStore, hash, object, extra)
+}
+
 // Shutdown shuts down the store gracefully
 func (s *singleFlightStore) Shutdown() {
 	s.ObjectStore.Shutdown()
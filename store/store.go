@@ -1,6 +1,10 @@
 package store
 
 import (
+	"bytes"
+	"io"
+	"time"
+
 	"github.com/lbryio/lbry.go/v2/extras/errors"
 	"github.com/lbryio/reflector.go/shared"
 )
@@ -20,6 +24,98 @@ type ObjectStore interface {
 	// Shutdown the store gracefully
 	Shutdown()
 }
+
+// StreamingObjectStore is an ObjectStore that can move objects around as streams so that
+// memory usage doesn't depend on the size of the objects being served.
+type StreamingObjectStore interface {
+	ObjectStore
+	// GetStream returns a stream of the object. The caller must close it. Must return ErrObjectNotFound if object is not in store.
+	GetStream(hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error)
+	// PutStream stores the object by reading exactly object.Size bytes from the stream. The caller closes the stream.
+	PutStream(hash string, object *ObjectStream, extra interface{}) error
+}
+
+// ObjectStream is an object being read from a store
+type ObjectStream struct {
+	io.ReadCloser
+	// Size is the length of the object in bytes
+	Size int64
+	// Meta is what's known about the object besides its content, nil if nothing is
+	Meta *ObjectMeta
+	// Name is the original name of the object when it's stored under a key derived from it (its hash, a chunk...),
+	// so that it can be looked up by name later
+	Name string
+}
+
+// NewObjectStream wraps a byte slice into an ObjectStream
+func NewObjectStream(object []byte) *ObjectStream {
+	return &ObjectStream{
+		ReadCloser: io.NopCloser(bytes.NewReader(object)),
+		Size:       int64(len(object)),
+	}
+}
+
+// GetStream gets the object from the store as a stream. Stores that can't stream are read in full and wrapped.
+func GetStream(s ObjectStore, hash string, extra interface{}) (*ObjectStream, shared.BlobTrace, error) {
+	if ss, ok := s.(StreamingObjectStore); ok {
+		return ss.GetStream(hash, extra)
+	}
+	object, trace, err := s.Get(hash, extra)
+	if err != nil {
+		return nil, trace, err
+	}
+	return NewObjectStream(object), trace, nil
+}
+
+// PutStream puts the object stream into the store. Stores that can't stream are handed the fully read object.
+func PutStream(s ObjectStore, hash string, object *ObjectStream, extra interface{}) error {
+	if ss, ok := s.(StreamingObjectStore); ok {
+		return ss.PutStream(hash, object, extra)
+	}
+	buf, err := readAll(object)
+	if err != nil {
+		return err
+	}
+	return s.Put(hash, buf, extra)
+}
+
+// readAll reads the whole stream making sure it's as long as advertised. Unlike io.ReadFull it doesn't drop the error
+// of the read completing the object, which is where verifying streams report a checksum mismatch.
+func readAll(object *ObjectStream) ([]byte, error) {
+	buf := make([]byte, object.Size)
+	read := 0
+	for read < len(buf) {
+		n, err := object.Read(buf[read:])
+		read += n
+		if err == io.EOF && read == len(buf) {
+			break
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, errors.Err(err)
+		}
+	}
+	return buf, nil
+}
+
+// Walker is implemented by stores that can enumerate the objects they hold
+type Walker interface {
+	// Walk calls fn for every object in the store, stopping at the first error
+	Walk(fn func(object StoredObject) error) error
+}
+
+// StoredObject is an object found while walking a store
+type StoredObject struct {
+	Hash    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// Misplaced is set when the object isn't where the store would look for it, so it can never be served
+	Misplaced bool
+}
+
 type BaseFuncs struct {
 	GetFunc func(hash string, extra interface{}) ([]byte, shared.BlobTrace, error)
 	HasFunc func(hash string, extra interface{}) (bool, error)